@@ -6,13 +6,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http/httputil"
 	"sort"
 
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pmylund/go-cache"
@@ -23,35 +26,248 @@ const Version = "0.4"
 
 var maxItemTime = cache.DefaultExpiration
 
-var store = func() *cache.Cache {
-	c := cache.New(5*time.Minute, 30*time.Second)
-	return c
-}()
+var allowedHttpMethods = []string{"OPTIONS", "GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"}
 
-var events = func() *cache.Cache {
-	c := cache.New(5*time.Minute, 30*time.Second)
-	return c
-}()
+// API is the struct for the json object that is passed to apidemic for registration.
+//
+// The endpoint may be a template with named parameters, like /users/{id} or /files/{path...},
+// in which case the parameters captured from the request path are recorded in the history.
+// Alternatively EndpointRegex matches the request path against a regular expression, named
+// capture groups are then captured the same way as the template parameters.
+//
+// Match optionally narrows down the requests served by the API, which allows several APIs
+// to share the same endpoint.
+//
+// ResponseCodeProbabilities maps response codes to the chance, in percent, that the API
+// responds with them instead of its regular response. ResponseCodePayloads optionally sets
+// the payload rendered with those codes. The choice is deterministic when Seed, or else the
+// seed of the server, is set, as is the fake data of the responses, see generatorFor. The
+// codes and delays are picked in turn from the requests the API serves, so the SeedHeader
+// of a request does not apply to them.
+//
+// Delay slows down every response of the API, unless the response sets its own Delay.
+//
+// Lang is the language of the fake data of the responses, unless their tags set another one.
+//
+// The Exactly responses are rendered once each in turn, OnExhausted tells what happens once
+// they all have been rendered, see exhaustionPolicies. With the fail policy the response code
+// is ExhaustedCode, 500 by default.
+//
+// APIs sharing a Scenario form a state machine. An API with a RequiredState only serves
+// requests while the scenario is in that state, an API with a NewState moves the scenario
+// to it once it serves a request. Every scenario starts in the ScenarioStarted state.
+type API struct {
+	Endpoint                  string                 `json:"endpoint"`
+	EndpointRegex             string                 `json:"endpoint_regex,omitempty"`
+	HTTPMethod                string                 `json:"http_method"`
+	Match                     *Match                 `json:"match,omitempty"`
+	Any                       *Response              `json:"any,omitempty"`
+	Exactly                   []Response             `json:"exactly,omitempty"`
+	ResponseCodeProbabilities map[string]float64     `json:"response_code_probabilities,omitempty"`
+	ResponseCodePayloads      map[string]interface{} `json:"response_code_payloads,omitempty"`
+	Seed                      *int64                 `json:"seed,omitempty"`
+	Delay                     *Delay                 `json:"delay,omitempty"`
+	OnExhausted               string                 `json:"on_exhausted,omitempty"`
+	ExhaustedCode             int                    `json:"exhausted_code,omitempty"`
+	Scenario                  string                 `json:"scenario,omitempty"`
+	RequiredState             string                 `json:"required_state,omitempty"`
+	NewState                  string                 `json:"new_state,omitempty"`
+	Lang                      string                 `json:"lang,omitempty"`
+
+	pattern *pathPattern
+	regex   *regexp.Regexp
+	codes   []codeProbability
+	state   *apiState
+}
 
-var allowedHttpMethods = []string{"OPTIONS", "GET", "POST", "PUT", "DELETE", "HEAD"}
+// apiState is the state of a registered API which lives as long as the API does. It is
+// shared by every copy of the API.
+type apiState struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+	// served is the number of Exactly responses rendered so far.
+	served int
+	// sequences holds the counters of the sequence tags of the responses.
+	sequences *sequences
+	// templates holds the templates of the responses, parsed at registration.
+	templates templates
+	// itemSeed is the seed of the paginated items when the request has no seed.
+	itemSeed int64
+}
+
+func newAPIState(seed *int64) *apiState {
+	s := time.Now().UnixNano()
+	if seed != nil {
+		s = *seed
+	}
+	r := rand.New(rand.NewSource(s))
+	return &apiState{rand: r, sequences: newSequences(), templates: make(templates), itemSeed: r.Int63()}
+}
 
-var mutex = &sync.Mutex{}
+func (s *apiState) float64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rand.Float64()
+}
 
-// API is the struct for the json object that is passed to apidemic for registration.
-type API struct {
-	Endpoint   string     `json:"endpoint"`
-	HTTPMethod string     `json:"http_method"`
-	Any        *Response  `json:"any,omitempty"`
-	Exactly    []Response `json:"exactly,omitempty"`
+func (s *apiState) normFloat64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rand.NormFloat64()
 }
 
+// Response describes what is rendered when a registered endpoint is called. The payload
+// keys may be annotated with tags, in which case fake data is generated for them on every
+// request. The payload strings may also be templates referring to the request, like
+// "{{ .Params.id }}" or "{{ .Body.user.name }}". Set Raw to serve the payload verbatim.
+//
+// Headers and Cookies are sent along with the payload, their values may be templates too.
+// The Content-Type header defaults to application/json.
+//
+// Fault breaks the response on purpose, see faults for the supported modes.
+//
+// Pagination serves an array payload one page at a time, see Pagination.
+//
+// Instead of a JSON payload the response can serve a raw Body, which may be a template, a
+// binary BodyBase64 or the content of BodyFile looked up in the fixtures directory of the
+// server, see WithFixturesDir. ContentType is
+// then sent unless a header sets it.
 type Response struct {
-	Code    int         `json:"code"`
-	Payload interface{} `json:"payload"`
+	Code        int                 `json:"code"`
+	Payload     interface{}         `json:"payload"`
+	Raw         bool                `json:"raw,omitempty"`
+	Headers     map[string][]string `json:"headers,omitempty"`
+	Cookies     []Cookie            `json:"cookies,omitempty"`
+	Body        string              `json:"body,omitempty"`
+	BodyBase64  string              `json:"body_base64,omitempty"`
+	BodyFile    string              `json:"body_file,omitempty"`
+	ContentType string              `json:"content_type,omitempty"`
+	Delay       *Delay              `json:"delay,omitempty"`
+	Fault       string              `json:"fault,omitempty"`
+	Pagination  *Pagination         `json:"pagination,omitempty"`
+
+	value Value
+}
+
+// Cookie is a cookie set by a response. SameSite is one of lax, strict or none.
+type Cookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	MaxAge   int        `json:"max_age,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+	HTTPOnly bool       `json:"http_only,omitempty"`
+	SameSite string     `json:"same_site,omitempty"`
+}
+
+var sameSiteModes = map[string]http.SameSite{
+	"":       http.SameSiteDefaultMode,
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// load checks the response and prepares it to be rendered, its templates are parsed into
+// tmpls.
+func (rsp *Response) load(fixturesDir string, tmpls templates) error {
+	if err := rsp.Delay.load(); err != nil {
+		return err
+	}
+	if _, ok := faults[rsp.Fault]; rsp.Fault != "" && !ok {
+		return fmt.Errorf("apidemic: bad fault %q", rsp.Fault)
+	}
+	for _, c := range rsp.Cookies {
+		if c.Name == "" {
+			return errors.New("apidemic: cookie name must be set")
+		}
+		if _, ok := sameSiteModes[strings.ToLower(c.SameSite)]; !ok {
+			return fmt.Errorf("apidemic: bad cookie %s same_site %q", c.Name, c.SameSite)
+		}
+		if err := tmpls.parse(c.Value); err != nil {
+			return err
+		}
+	}
+	for _, values := range rsp.Headers {
+		for _, v := range values {
+			if err := tmpls.parse(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := rsp.loadRawBody(fixturesDir, tmpls); err != nil {
+		return err
+	}
+	if rsp.Pagination != nil && (rsp.Raw || rsp.hasRawBody()) {
+		return errors.New("apidemic: only a payload can be paginated")
+	}
+	if rsp.Raw || rsp.hasRawBody() {
+		return nil
+	}
+	if err := tmpls.parse(rsp.Payload); err != nil {
+		return err
+	}
+	if err := checkTags(rsp.Payload); err != nil {
+		return err
+	}
+	if rsp.Pagination != nil {
+		return rsp.Pagination.load(rsp.Payload)
+	}
+	value, err := loadValue(rsp.Payload)
+	if err != nil {
+		return err
+	}
+	rsp.value = value
+	return nil
+}
+
+// header renders the headers and cookies of the response for the request described by data.
+func (rsp Response) header(data *templateData) (http.Header, error) {
+	header := make(http.Header)
+	for key, values := range rsp.Headers {
+		for _, v := range values {
+			rendered, err := renderTemplate(v, data)
+			if err != nil {
+				return nil, err
+			}
+			header.Add(key, rendered)
+		}
+	}
+	for _, c := range rsp.Cookies {
+		value, err := renderTemplate(c.Value, data)
+		if err != nil {
+			return nil, err
+		}
+		cookie := &http.Cookie{
+			Name:     c.Name,
+			Value:    value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			MaxAge:   c.MaxAge,
+			Secure:   c.Secure,
+			HttpOnly: c.HTTPOnly,
+			SameSite: sameSiteModes[strings.ToLower(c.SameSite)],
+		}
+		if c.Expires != nil {
+			cookie.Expires = *c.Expires
+		}
+		header.Add("Set-Cookie", cookie.String())
+	}
+	return header, nil
+}
+
+// body generates the payload of the response for the request described by data.
+func (rsp Response) body(data *templateData) (interface{}, error) {
+	if rsp.Raw {
+		return rsp.Payload, nil
+	}
+	return renderTemplates(rsp.value.generate(data.gen), data)
 }
 
 // Home renders hopme page. It renders a json response with information about the service.
-func HomeHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	details := make(map[string]interface{})
 	details["app_name"] = "ApiDemic"
 	details["version"] = Version
@@ -69,7 +285,7 @@ func code(code int) int {
 }
 
 // RenderJSON helper for rendering JSON response, it marshals value into json and writes
-// it into w.
+// it into w. The Content-Type header is set to application/json unless it is already set.
 func RenderJSON(w http.ResponseWriter, code int, value interface{}) {
 	if code == http.StatusNoContent {
 		log.Printf("response: %d", http.StatusNoContent)
@@ -77,7 +293,9 @@ func RenderJSON(w http.ResponseWriter, code int, value interface{}) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(code)
 	b, err := json.Marshal(value)
 	if err != nil {
@@ -90,12 +308,12 @@ func RenderJSON(w http.ResponseWriter, code int, value interface{}) {
 		log.Printf("response: body write failed: %s", err)
 	}
 
-	log.Printf("response: %d\nContent-Type: application/json\n%s\n\n", code, string(b))
+	log.Printf("response: %d\nContent-Type: %s\n%s\n\n", code, w.Header().Get("Content-Type"), string(b))
 }
 
 // RegisterEndpoint receives API objects and registers them. The payload from the request is
 // transformed into a self aware Value that is capable of faking its own attribute.
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var httpMethod string
 	a := API{}
 	err := json.NewDecoder(r.Body).Decode(&a)
@@ -113,11 +331,128 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	eKey := getCacheKeys(a.Endpoint, httpMethod)
-	store.Set(eKey, a, maxItemTime)
+	a.HTTPMethod = httpMethod
+	if err := a.load(s.fixturesDir, s.seed); err != nil {
+		log.Print(err)
+
+		RenderJSON(w, http.StatusBadRequest, NewResponse(err.Error()))
+		return
+	}
+
+	eKey := getCacheKeys(a.route()+a.Match.signature()+a.scenarioSignature(), httpMethod)
+	s.sessionToWrite(r).store.Set(eKey, a, maxItemTime)
 	RenderJSON(w, http.StatusOK, NewResponse("cool"))
 }
 
+// load checks the API and prepares it to serve requests. The response codes and delays are
+// picked from a source seeded with the Seed of the API, else with serverSeed.
+func (a *API) load(fixturesDir string, serverSeed *int64) error {
+	if a.EndpointRegex != "" {
+		if a.Endpoint != "" {
+			return errors.New("apidemic: either endpoint or endpoint_regex must be set, not both")
+		}
+		regex, err := regexp.Compile(a.EndpointRegex)
+		if err != nil {
+			return fmt.Errorf("apidemic: bad endpoint_regex: %s", err)
+		}
+		a.regex = regex
+	} else {
+		pattern, err := parsePathPattern(a.Endpoint)
+		if err != nil {
+			return err
+		}
+		a.pattern = pattern
+	}
+
+	if err := a.Match.load(); err != nil {
+		return err
+	}
+	if err := a.Delay.load(); err != nil {
+		return err
+	}
+	seed := a.Seed
+	if seed == nil {
+		seed = serverSeed
+	}
+	a.state = newAPIState(seed)
+	if err := a.loadCodeProbabilities(fixturesDir); err != nil {
+		return err
+	}
+	if err := a.loadExhaustion(); err != nil {
+		return err
+	}
+	if err := a.loadScenario(); err != nil {
+		return err
+	}
+	if a.Lang != "" {
+		if err := CheckLang(a.Lang); err != nil {
+			return fmt.Errorf("apidemic: %s", err)
+		}
+	}
+
+	if a.Any != nil {
+		if err := a.Any.load(fixturesDir, a.state.templates); err != nil {
+			return err
+		}
+	}
+	for i := range a.Exactly {
+		if err := a.Exactly[i].load(fixturesDir, a.state.templates); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// route identifies the endpoint of the API, regular expressions are prefixed with ~ so they
+// never collide with a plain endpoint.
+func (a API) route() string {
+	if a.regex != nil {
+		return "~" + a.EndpointRegex
+	}
+	return a.Endpoint
+}
+
+// matchPath reports whether path is served by the API and returns the parameters captured
+// from it.
+func (a API) matchPath(path string) (map[string]string, bool) {
+	if a.regex == nil {
+		return a.pattern.match(path)
+	}
+
+	m := a.regex.FindStringSubmatch(path)
+	if m == nil {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for i, name := range a.regex.SubexpNames() {
+		if name != "" {
+			params[name] = m[i]
+		}
+	}
+	return params, true
+}
+
+// moreSpecific reports whether a should be preferred over o when both match the same path.
+// Endpoint templates are always preferred over regular expressions, the API with the most
+// match conditions is preferred among equally specific endpoints, then the API requiring a
+// scenario state.
+func (a API) moreSpecific(o API) bool {
+	switch {
+	case a.regex == nil && o.regex != nil:
+		return true
+	case a.regex != nil && o.regex == nil:
+		return false
+	case a.regex == nil && a.pattern.moreSpecific(o.pattern):
+		return true
+	case a.regex == nil && o.pattern.moreSpecific(a.pattern):
+		return false
+	}
+	if a.Match.weight() != o.Match.weight() {
+		return a.Match.weight() > o.Match.weight()
+	}
+	return a.RequiredState != "" && o.RequiredState == ""
+}
+
 func getCacheKeys(endpoint, httpMethod string) string {
 	return fmt.Sprintf("%s-%v-e", endpoint, httpMethod)
 }
@@ -137,102 +472,371 @@ func getAllowedMethod(method string) (string, error) {
 }
 
 // DynamicEndpoint renders registered endpoints.
-func DynamicEndpoint(w http.ResponseWriter, r *http.Request) {
+func (s *Server) DynamicEndpoint(w http.ResponseWriter, r *http.Request) {
+	ss := s.session(r)
 	path := r.URL.Path
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("request: read body failed: %s", err)
-		events.Set(strconv.Itoa(int(time.Now().UnixNano())), map[string]interface{}{
-			"endpoint":        path,
-			"request_uri":     r.URL.RequestURI(),
-			"body":            "",
-			"headers":         r.Header,
-			"response_status": http.StatusInternalServerError,
-			"response_body":   nil,
-			"time":            time.Now().Format(time.RFC3339Nano),
-		}, maxItemTime)
+		event := newEvent(r, "")
+		event["response_status"] = http.StatusInternalServerError
+		ss.saveEvent(event)
 
 		RenderJSON(w, http.StatusInternalServerError, NewResponse(err.Error()))
 		return
 	}
 
-	eKey := getCacheKeys(path, r.Method)
-	if eVal, ok := store.Get(eKey); ok {
-		api := eVal.(API)
-		if api.Any != nil {
-			events.Set(strconv.Itoa(int(time.Now().UnixNano())), map[string]interface{}{
-				"endpoint":        path,
-				"request_uri":     r.URL.RequestURI(),
-				"body":            string(body),
-				"headers":         r.Header,
-				"response_status": code(api.Any.Code),
-				"response_body":   api.Any.Payload,
-				"time":            time.Now().Format(time.RFC3339Nano),
-			}, maxItemTime)
-
-			RenderJSON(w, code(api.Any.Code), api.Any.Payload)
+	event := newEvent(r, string(body))
+	var reasons []string
+	for {
+		api, params, failed, ok := ss.findAPI(r, body)
+		reasons = failed
+		if !ok {
+			break
+		}
+		event["params"] = params
+		gen, err := s.generatorFor(r, body, &api)
+		if err != nil {
+			event["response_status"] = http.StatusBadRequest
+			ss.saveEvent(event)
 
+			RenderJSON(w, http.StatusBadRequest, NewResponse(err.Error()))
+			return
+		}
+		data := newTemplateData(r, body, params, gen)
+		data.templates = api.state.templates
+		if c, ok := api.pickCode(); ok {
+			event["response_branch"] = strconv.Itoa(c.code)
+			s.renderResponse(w, r, ss, api, c.response, data, event)
+			return
+		}
+		if rsp, branch, ok := api.nextResponse(); ok {
+			event["response_branch"] = branch
+			// The scenario moves on once the response of the API is out, injected codes
+			// and canceled requests leave it as is.
+			if s.renderResponse(w, r, ss, api, rsp, data, event) && api.NewState != "" {
+				ss.scenarios.set(api.Scenario, api.NewState)
+			}
 			return
-		} else if api.Exactly != nil {
-			mutex.Lock()
-			defer mutex.Unlock()
-			if len(api.Exactly) > 0 {
-				var apirsp Response
-				apirsp, api.Exactly = api.Exactly[0], api.Exactly[1:]
-				store.Set(eKey, api, maxItemTime)
-
-				events.Set(strconv.Itoa(int(time.Now().UnixNano())), map[string]interface{}{
-					"endpoint":        path,
-					"request_uri":     r.URL.RequestURI(),
-					"body":            string(body),
-					"headers":         r.Header,
-					"response_status": code(apirsp.Code),
-					"response_body":   apirsp.Payload,
-					"time":            time.Now().Format(time.RFC3339Nano),
-				}, maxItemTime)
-
-				RenderJSON(w, code(apirsp.Code), apirsp.Payload)
-
-				return
+		}
+		// A concurrent request took the last response of the API, which is now skipped.
+	}
+
+	if ss.serveResource(w, r, body, event) {
+		return
+	}
+
+	responseText := fmt.Sprintf("apidemic: %s has no %s endpoint", path, r.Method)
+	if len(reasons) > 0 {
+		responseText = fmt.Sprintf("apidemic: %s has no %s endpoint matching the request: %s", path, r.Method, strings.Join(reasons, "; "))
+	}
+	rsp := struct {
+		Text       string     `json:"text"`
+		NearMisses []NearMiss `json:"near_misses"`
+	}{
+		responseText,
+		ss.findNearMisses(r, body),
+	}
+
+	event["response_status"] = http.StatusNotFound
+	event["response_body"] = rsp
+	event["near_misses"] = rsp.NearMisses
+	ss.saveEvent(event)
+
+	RenderJSON(w, http.StatusNotFound, rsp)
+}
+
+// nextResponse returns the response the API renders next along with the branch recorded in
+// the history, Exactly responses are rendered once each in turn and then as OnExhausted
+// tells. Unless OnExhausted is then_any, Any always wins over Exactly.
+func (a API) nextResponse() (Response, string, bool) {
+	if a.Any != nil && a.OnExhausted != "then_any" {
+		return *a.Any, "default", true
+	}
+	if len(a.Exactly) == 0 {
+		if a.Any != nil {
+			return *a.Any, "default", true
+		}
+		return Response{}, "", false
+	}
+
+	a.state.mu.Lock()
+	i := a.state.served
+	a.state.served++
+	a.state.mu.Unlock()
+
+	if i < len(a.Exactly) {
+		return a.Exactly[i], "default", true
+	}
+	switch a.OnExhausted {
+	case "then_any":
+		return *a.Any, "exhausted", true
+	case "repeat_last":
+		return a.Exactly[len(a.Exactly)-1], "exhausted", true
+	case "cycle":
+		return a.Exactly[i%len(a.Exactly)], "exhausted", true
+	case "fail":
+		code := a.ExhaustedCode
+		if code == 0 {
+			code = http.StatusInternalServerError
+		}
+		return Response{
+			Code:    code,
+			Payload: NewResponse(fmt.Sprintf("apidemic: %s has no response left", a.route())),
+			Raw:     true,
+		}, "exhausted", true
+	}
+	return Response{}, "", false
+}
+
+// exhausted reports whether the API has no response left to render, it is then skipped when
+// matching requests. Only APIs without an Any response and the default OnExhausted policy
+// run out of responses.
+func (a API) exhausted() bool {
+	if a.Any != nil {
+		return false
+	}
+	if len(a.Exactly) == 0 {
+		return true
+	}
+	if a.OnExhausted != "" {
+		return false
+	}
+	a.state.mu.Lock()
+	defer a.state.mu.Unlock()
+	return a.state.served >= len(a.Exactly)
+}
+
+// exhaustionPolicies describes the supported API.OnExhausted policies.
+var exhaustionPolicies = map[string]string{
+	"":            "no response, the next matching API serves the request",
+	"then_any":    "the Any response",
+	"repeat_last": "the last Exactly response",
+	"cycle":       "the Exactly responses again, from the first",
+	"fail":        "an error with the ExhaustedCode",
+}
+
+func (a *API) loadExhaustion() error {
+	if _, ok := exhaustionPolicies[a.OnExhausted]; !ok {
+		return fmt.Errorf("apidemic: bad on_exhausted %q", a.OnExhausted)
+	}
+	if a.OnExhausted == "then_any" && a.Any == nil {
+		return errors.New("apidemic: on_exhausted then_any needs an any response")
+	}
+	if a.ExhaustedCode != 0 {
+		if a.OnExhausted != "fail" {
+			return errors.New("apidemic: exhausted_code is only used with on_exhausted fail")
+		}
+		if a.ExhaustedCode < 100 || a.ExhaustedCode > 599 {
+			return fmt.Errorf("apidemic: bad exhausted_code %d", a.ExhaustedCode)
+		}
+	}
+	return nil
+}
+
+// renderResponse writes rsp and records it in event. It reports whether rsp was written, that
+// is neither failed, canceled during its delay nor dropped by a fault closing the connection.
+func (s *Server) renderResponse(w http.ResponseWriter, r *http.Request, ss *session, api API, rsp Response, data *templateData, event map[string]interface{}) (written bool) {
+	var (
+		rspBody interface{}
+		raw     []byte
+	)
+	var pg page
+	if rsp.Pagination != nil {
+		var err error
+		if pg, err = rsp.Pagination.window(r.URL.Query()); err != nil {
+			event["response_status"] = http.StatusBadRequest
+			ss.saveEvent(event)
+
+			RenderJSON(w, http.StatusBadRequest, NewResponse(err.Error()))
+			return false
+		}
+	}
+
+	header, err := rsp.header(data)
+	if err == nil && rsp.hasRawBody() {
+		raw, err = rsp.rawBody(s.fixturesDir, data)
+		rspBody = historyBody(raw)
+	} else if err == nil && rsp.Pagination != nil {
+		var pageHeader http.Header
+		rspBody, pageHeader, err = rsp.Pagination.paginate(r, pg, data)
+		for key, values := range pageHeader {
+			header[key] = values
+		}
+	} else if err == nil {
+		rspBody, err = rsp.body(data)
+	}
+	if err != nil {
+		log.Print(err)
+		event["response_status"] = http.StatusInternalServerError
+		ss.saveEvent(event)
+
+		RenderJSON(w, http.StatusInternalServerError, NewResponse(err.Error()))
+		return false
+	}
+
+	event["response_status"] = code(rsp.Code)
+	event["response_headers"] = header
+	event["response_body"] = rspBody
+	defer ss.saveEvent(event)
+
+	delay := rsp.Delay
+	if delay == nil {
+		delay = api.Delay
+	}
+	if delay != nil {
+		d := delay.duration(api.state)
+		event["delay_ms"] = float64(d) / float64(time.Millisecond)
+		// Faults write the connection directly, the body cannot be slowed down.
+		if delay.Mode == "total" && rsp.Fault == "" {
+			sw := &slowWriter{ResponseWriter: w, ctx: r.Context(), duration: d}
+			defer func() {
+				if sw.canceled {
+					event["canceled"] = true
+					written = false
+				}
+			}()
+			w = sw
+		} else if !sleep(r.Context(), d) {
+			log.Printf("response: request canceled during a %s delay", d)
+			event["canceled"] = true
+			return false
+		}
+	}
+
+	for key, values := range header {
+		w.Header()[key] = values
+	}
+	if rsp.Fault != "" {
+		event["fault"] = rsp.Fault
+		if rsp.hasRawBody() && w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", rsp.contentType())
+		}
+		if !rsp.hasRawBody() {
+			if w.Header().Get("Content-Type") == "" {
+				w.Header().Set("Content-Type", "application/json")
 			}
+			raw, _ = json.Marshal(rspBody)
+		}
+		return renderFault(w, r, rsp.Fault, code(rsp.Code), raw)
+	}
+	if rsp.hasRawBody() {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", rsp.contentType())
+		}
+		RenderRaw(w, code(rsp.Code), raw)
+		return true
+	}
+	RenderJSON(w, code(rsp.Code), rspBody)
+	return true
+}
+
+// exhaustedReason is why an API which has no response left does not match a request.
+const exhaustedReason = "responses: exhausted"
+
+// findAPI looks up the registered API serving the request. When several APIs match the
+// request the most specific one wins, APIs which have no response left are skipped. If APIs are registered for the path but none of them
+// matches the request, the reasons why the most specific one does not are returned.
+func (s *session) findAPI(r *http.Request, body []byte) (API, map[string]string, []string, bool) {
+	var (
+		found     bool
+		eKey      string
+		api       API
+		apiParams map[string]string
+
+		missed  bool
+		missAPI API
+		reasons []string
+	)
+	for key, item := range s.store.Items() {
+		a := item.Object.(API)
+		if a.HTTPMethod != r.Method {
+			continue
+		}
+		params, ok := a.matchPath(r.URL.Path)
+		if !ok {
+			continue
+		}
+		failed := append(a.Match.check(r, body), a.checkScenario(s.scenarios)...)
+		if a.exhausted() {
+			failed = append(failed, exhaustedReason)
+		}
+		if len(failed) > 0 {
+			if !missed || a.moreSpecific(missAPI) {
+				missed, missAPI, reasons = true, a, failed
+			}
+			continue
+		}
+		if found && (api.moreSpecific(a) || (!a.moreSpecific(api) && key > eKey)) {
+			continue
 		}
+
+		found, eKey, api, apiParams = true, key, a, params
 	}
 
-	events.Set(strconv.Itoa(int(time.Now().UnixNano())), map[string]interface{}{
-		"endpoint":        path,
+	return api, apiParams, reasons, found
+}
+
+func newEvent(r *http.Request, body string) map[string]interface{} {
+	return map[string]interface{}{
+		"endpoint":        r.URL.Path,
 		"request_uri":     r.URL.RequestURI(),
-		"body":            string(body),
+		"body":            body,
 		"headers":         r.Header,
-		"response_status": http.StatusNotFound,
+		"params":          map[string]string{},
+		"response_status": 0,
 		"response_body":   nil,
 		"time":            time.Now().Format(time.RFC3339Nano),
-	}, maxItemTime)
+	}
+}
 
-	responseText := fmt.Sprintf("apidemic: %s has no %s endpoint", path, r.Method)
-	RenderJSON(w, http.StatusNotFound, NewResponse(responseText))
+// saveEvent records event in the history. Events are keyed by a sequence number so that
+// the history lists them in order.
+func (s *session) saveEvent(event map[string]interface{}) {
+	seq := atomic.AddUint64(&s.eventSeq, 1)
+	s.events.Set(fmt.Sprintf("%020d", seq), event, maxItemTime)
 }
 
-func HistoryHandler(w http.ResponseWriter, r *http.Request) {
-	result := make([]cache.Item, 0)
-	for _, item := range events.Items() {
-		result = append(result, item)
+func (s *Server) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	out := make([]interface{}, 0)
+	for _, event := range s.session(r).history() {
+		out = append(out, event)
 	}
 
-	sort.Slice(result, func(i, j int) bool {
-		return result[i].Expiration < result[j].Expiration
-	})
+	RenderJSON(w, http.StatusOK, out)
+}
 
+// UnmatchedHandler renders the history entries of the requests which did not match any
+// registered endpoint, along with the endpoints which came closest.
+func (s *Server) UnmatchedHandler(w http.ResponseWriter, r *http.Request) {
 	out := make([]interface{}, 0)
-	for i := range result {
-		out = append(out, result[i].Object)
+	for _, event := range s.session(r).history() {
+		if _, ok := event["near_misses"]; ok {
+			out = append(out, event)
+		}
 	}
 
 	RenderJSON(w, http.StatusOK, out)
 }
 
-func ResetHandler(w http.ResponseWriter, r *http.Request) {
-	events.Flush()
-	store.Flush()
+func (s *session) history() []map[string]interface{} {
+	items := s.events.Items()
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, items[key].Object.(map[string]interface{}))
+	}
+	return out
+}
+
+// ResetHandler drops the registered endpoints and the history of the session of the request.
+func (s *Server) ResetHandler(w http.ResponseWriter, r *http.Request) {
+	s.resetSession(r)
 
 	RenderJSON(w, http.StatusOK, nil)
 }
@@ -246,28 +850,6 @@ func NewResponse(message string) interface{} {
 	}
 }
 
-// NewServer returns a new apidemic server
-func NewServer() http.Handler {
-	handler := &RegexpHandler{}
-
-	reg, _ := regexp.Compile("^/_register$")
-	handler.HandleFunc(reg, RegisterHandler)
-
-	reg, _ = regexp.Compile("^/_$")
-	handler.HandleFunc(reg, HomeHandler)
-
-	reg, _ = regexp.Compile("^/_history$")
-	handler.HandleFunc(reg, HistoryHandler)
-
-	reg, _ = regexp.Compile("^/_reset$")
-	handler.HandleFunc(reg, ResetHandler)
-
-	reg, _ = regexp.Compile("^.+")
-	handler.HandleFunc(reg, DynamicEndpoint)
-
-	return handler
-}
-
 type RegexpHandler struct {
 	routes []*route
 }
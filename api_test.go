@@ -2,16 +2,19 @@ package apidemic
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/pmylund/go-cache"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -154,10 +157,1117 @@ func TestDynamicEndpointSaveRequestOrderInHistory(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
-func setUp() http.Handler {
-	store = cache.New(5*time.Minute, 30*time.Second)
+func TestDynamicEndpointGeneratesFakeData(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	payload := API{
+		Endpoint: "/api/test",
+		Any: &Response{
+			Payload: map[string]interface{}{
+				"name:first_name": "anton",
+				"list:word,max=3": []interface{}{"first"},
+				"country":         "Sweden",
+			},
+		},
+	}
+
+	req := jsonRequest("POST", "/_register", payload)
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/api/test", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var got map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	assert.NotContains(t, got, "name:first_name")
+	assert.NotEmpty(t, got["name"])
+	assert.Len(t, got["list"], 3)
+	assert.Equal(t, "Sweden", got["country"])
+}
+
+func TestDynamicEndpointKeepsUntaggedArrays(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	for endpoint, payload := range map[string]interface{}{
+		"/object": map[string]interface{}{
+			"ids":  []interface{}{1, 2, 3},
+			"objs": []interface{}{map[string]interface{}{"x": 1}, map[string]interface{}{"x": 2}},
+		},
+		"/array": []interface{}{"x", "y"},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", API{Endpoint: endpoint, Any: &Response{Payload: payload}}))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/object", ""))
+	assert.JSONEq(t, `{"ids":[1,2,3],"objs":[{"x":1},{"x":2}]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/array", ""))
+	assert.JSONEq(t, `["x","y"]`, w.Body.String())
+}
+
+func TestDynamicEndpointRawPayload(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	payload := API{
+		Endpoint: "/api/test",
+		Any: &Response{
+			Raw: true,
+			Payload: map[string]interface{}{
+				"name:first_name": "anton",
+			},
+		},
+	}
+
+	req := jsonRequest("POST", "/_register", payload)
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/api/test", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name:first_name":"anton"}`, w.Body.String())
+}
+
+func TestDynamicEndpointWithPathTemplate(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	for endpoint, payload := range map[string]string{
+		"/users/{id}": "user",
+		"/users/me":   "me",
+	} {
+		req := jsonRequest("POST", "/_register", API{
+			Endpoint: endpoint,
+			Any:      &Response{Payload: payload},
+		})
+		s.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/users/me", ""))
+	assert.JSONEq(t, `"me"`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/users/42", ""))
+	assert.JSONEq(t, `"user"`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/_history", ""))
+
+	var history []struct {
+		Endpoint string            `json:"endpoint"`
+		Params   map[string]string `json:"params"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.Len(t, history, 2)
+	assert.Equal(t, map[string]string{}, history[0].Params)
+	assert.Equal(t, map[string]string{"id": "42"}, history[1].Params)
+}
+
+func TestRegisterRejectsInvalidPathTemplate(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	req := jsonRequest("POST", "/_register", API{
+		Endpoint: "/files/{path...}/raw",
+		Any:      &Response{},
+	})
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDynamicEndpointWithRegex(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	req := jsonRequest("POST", "/_register", API{
+		EndpointRegex: `^/v[12]/orders/(?P<id>\d+)$`,
+		Any:           &Response{Payload: "regex"},
+	})
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	req = jsonRequest("POST", "/_register", API{
+		Endpoint: "/v1/orders/{id}",
+		Any:      &Response{Payload: "template"},
+	})
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/v2/orders/42", ""))
+	assert.JSONEq(t, `"regex"`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/v1/orders/42", ""))
+	assert.JSONEq(t, `"template"`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/v3/orders/42", ""))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/_history", ""))
+
+	var history []struct {
+		Params map[string]string `json:"params"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.Len(t, history, 3)
+	assert.Equal(t, map[string]string{"id": "42"}, history[0].Params)
+}
+
+func TestRegisterRejectsInvalidRegex(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	req := jsonRequest("POST", "/_register", API{
+		EndpointRegex: `^/orders/(\d+$`,
+		Any:           &Response{},
+	})
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "missing closing )")
+}
+
+func TestDynamicEndpointWithMatch(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	prod := "prod"
+	absent := false
+	for _, api := range []API{
+		{
+			Endpoint:   "/api/test",
+			HTTPMethod: "POST",
+			Any:        &Response{Payload: "any"},
+			Match: &Match{
+				Headers: map[string]*ValueMatcher{"X-Debug": {Present: &absent}},
+			},
+		},
+		{
+			Endpoint:   "/api/test",
+			HTTPMethod: "POST",
+			Any:        &Response{Payload: "prod"},
+			Match: &Match{
+				Query:   map[string]*ValueMatcher{"env": {Equals: &prod}},
+				Headers: map[string]*ValueMatcher{"X-Debug": {Present: &absent}},
+				Body: &BodyMatcher{
+					Subset: map[string]interface{}{"user": map[string]interface{}{"active": true}},
+					Paths:  []*PathMatcher{{Path: "$.user.id", Regex: "^u-"}},
+				},
+			},
+		},
+	} {
+		req := jsonRequest("POST", "/_register", api)
+		s.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/api/test?env=prod", map[string]interface{}{
+		"user": map[string]interface{}{"id": "u-1", "active": true},
+	}))
+	assert.JSONEq(t, `"prod"`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/api/test?env=dev", map[string]interface{}{
+		"user": map[string]interface{}{"id": "u-1", "active": true},
+	}))
+	assert.JSONEq(t, `"any"`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req := jsonRequest("POST", "/api/test?env=prod", "")
+	req.Header.Set("X-Debug", "1")
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "header X-Debug: is present")
+}
+
+func TestRegisterRejectsInvalidMatch(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	req := jsonRequest("POST", "/_register", API{
+		Endpoint: "/api/test",
+		Any:      &Response{},
+		Match: &Match{
+			Headers: map[string]*ValueMatcher{"X-Env": {Regex: "(prod"}},
+		},
+	})
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDynamicEndpointReportsNearMisses(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	for _, api := range []API{
+		{Endpoint: "/api/test", HTTPMethod: "POST", Any: &Response{}},
+		{Endpoint: "/api/test/", HTTPMethod: "GET", Any: &Response{}},
+		{Endpoint: "/api/other", HTTPMethod: "GET", Any: &Response{}},
+	} {
+		req := jsonRequest("POST", "/_register", api)
+		s.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/api/test", ""))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	expected := []NearMiss{
+		{Endpoint: "/api/test", HTTPMethod: "POST", Reasons: []string{"method: expected POST, got GET"}},
+		{Endpoint: "/api/test/", HTTPMethod: "GET", Reasons: []string{"path: differs by a trailing slash"}},
+	}
+
+	var rsp struct {
+		NearMisses []NearMiss `json:"near_misses"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+	assert.Equal(t, expected, rsp.NearMisses)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/api/test", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/_unmatched", ""))
+
+	var unmatched []struct {
+		Endpoint   string     `json:"endpoint"`
+		NearMisses []NearMiss `json:"near_misses"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&unmatched))
+	require.Len(t, unmatched, 1)
+	assert.Equal(t, "/api/test", unmatched[0].Endpoint)
+	assert.Equal(t, expected, unmatched[0].NearMisses)
+}
+
+func TestDynamicEndpointRendersTemplates(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	req := jsonRequest("POST", "/_register", API{
+		Endpoint:   "/users/{id}",
+		HTTPMethod: "POST",
+		Any: &Response{
+			Code: http.StatusCreated,
+			Payload: map[string]interface{}{
+				"id":   "{{ .Params.id }}",
+				"name": "{{ .Body.name }}",
+			},
+		},
+	})
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/users/42", map[string]interface{}{"name": "anton"}))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"id":"42","name":"anton"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req = jsonRequest("POST", "/_register", API{
+		Endpoint: "/broken",
+		Any:      &Response{Payload: "{{ .Body.name"},
+	})
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDynamicEndpointDoesNotRenderGeneratedTemplates(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	req := jsonRequest("POST", "/_register", API{
+		Endpoint: "/codes",
+		Any: &Response{Payload: map[string]interface{}{
+			"code:pattern,regex='\\{\\{ \\.Foo \\}\\}'": "",
+			"pick:one_of,values='{{ .Bar }}'":           "",
+			"id":                                        "{{ .Path }}",
+		}},
+	})
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("GET", "/codes", ""))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"code":"{{ .Foo }}","pick":"{{ .Bar }}","id":"/codes"}`, w.Body.String())
+	}
+}
+
+func TestDynamicEndpointWithHeadersAndCookies(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	req := jsonRequest("POST", "/_register", API{
+		Endpoint:   "/users/{id}",
+		HTTPMethod: "POST",
+		Any: &Response{
+			Code: http.StatusCreated,
+			Headers: map[string][]string{
+				"Location":     {"/users/{{ .Params.id }}"},
+				"Link":         {`</users?page=2>; rel="next"`, `</users?page=9>; rel="last"`},
+				"Content-Type": {"application/vnd.api+json"},
+			},
+			Cookies: []Cookie{
+				{Name: "session", Value: "abc", Path: "/", HTTPOnly: true, SameSite: "lax"},
+			},
+		},
+	})
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/users/42", ""))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/users/42", w.Header().Get("Location"))
+	assert.Equal(t, []string{`</users?page=2>; rel="next"`, `</users?page=9>; rel="last"`}, w.Header()["Link"])
+	assert.Equal(t, "application/vnd.api+json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "session=abc; Path=/; HttpOnly; SameSite=Lax", w.Header().Get("Set-Cookie"))
+
+	w = httptest.NewRecorder()
+	req = jsonRequest("POST", "/_register", API{
+		Endpoint: "/broken",
+		Any: &Response{
+			Cookies: []Cookie{{Name: "session", SameSite: "sometimes"}},
+		},
+	})
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDynamicEndpointWithRawBody(t *testing.T) {
+	s := setUp(WithFixturesDir("fixtures"))
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	xml, err := ioutil.ReadFile("fixtures/sample.xml")
+	require.NoError(t, err)
+
+	for _, api := range []API{
+		{Endpoint: "/text/{id}", Any: &Response{Body: "id,name\n{{ .Params.id }},anton\n", ContentType: "text/csv"}},
+		{Endpoint: "/binary", Any: &Response{BodyBase64: "iVBORw0KGgo=", ContentType: "image/png"}},
+		{Endpoint: "/file", Any: &Response{BodyFile: "sample.xml"}},
+	} {
+		req := jsonRequest("POST", "/_register", api)
+		s.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/text/42", ""))
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "id,name\n42,anton\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/binary", ""))
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}, w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/file", ""))
+	assert.Equal(t, "text/xml; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, xml, w.Body.Bytes())
+
+	for _, api := range []API{
+		{Endpoint: "/missing", Any: &Response{BodyFile: "../api.go"}},
+		{Endpoint: "/both", Any: &Response{Body: "text", Payload: "json"}},
+	} {
+		w = httptest.NewRecorder()
+		req := jsonRequest("POST", "/_register", api)
+		s.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestDynamicEndpointWithResponseCodeProbabilities(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	seed := int64(42)
+	api := API{
+		Endpoint: "/api/test",
+		Any:      &Response{Payload: "ok"},
+		ResponseCodeProbabilities: map[string]float64{
+			"503": 30,
+			"418": 20,
+		},
+		ResponseCodePayloads: map[string]interface{}{
+			"503": map[string]interface{}{"error": "down"},
+		},
+		Seed: &seed,
+	}
+
+	codes := func() []int {
+		req := jsonRequest("POST", "/_register", api)
+		s.ServeHTTP(httptest.NewRecorder(), req)
+
+		var codes []int
+		for i := 0; i < 200; i++ {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, jsonRequest("GET", "/api/test", ""))
+			switch w.Code {
+			case http.StatusServiceUnavailable:
+				assert.JSONEq(t, `{"error":"down"}`, w.Body.String())
+			case http.StatusOK:
+				assert.JSONEq(t, `"ok"`, w.Body.String())
+			}
+			codes = append(codes, w.Code)
+		}
+		return codes
+	}
+
+	first := codes()
+	count := make(map[int]int)
+	for _, c := range first {
+		count[c]++
+	}
+	assert.InDelta(t, 60, count[http.StatusServiceUnavailable], 20)
+	assert.InDelta(t, 40, count[http.StatusTeapot], 20)
+	assert.InDelta(t, 100, count[http.StatusOK], 25)
+
+	assert.Equal(t, first, codes())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/_history", ""))
+
+	var history []struct {
+		ResponseStatus int    `json:"response_status"`
+		ResponseBranch string `json:"response_branch"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.Len(t, history, 400)
+	for _, entry := range history {
+		if entry.ResponseStatus == http.StatusOK {
+			assert.Equal(t, "default", entry.ResponseBranch)
+			continue
+		}
+		assert.Equal(t, strconv.Itoa(entry.ResponseStatus), entry.ResponseBranch)
+	}
+
+	api.ResponseCodeProbabilities["500"] = 60
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/_register", api))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDynamicEndpointCodesFollowServerSeed(t *testing.T) {
+	api := API{
+		Endpoint:                  "/api/test",
+		Any:                       &Response{Payload: "ok"},
+		ResponseCodeProbabilities: map[string]float64{"503": 50},
+		Delay:                     &Delay{Distribution: "uniform", MinMs: 0, MaxMs: 1},
+	}
+	codes := func() ([]int, []float64) {
+		s := setUp(WithSeed(1))
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", api))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var codes []int
+		for i := 0; i < 50; i++ {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, jsonRequest("GET", "/api/test", ""))
+			codes = append(codes, w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("GET", "/_history", ""))
+		var history []struct {
+			DelayMs float64 `json:"delay_ms"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+		var delays []float64
+		for _, entry := range history {
+			delays = append(delays, entry.DelayMs)
+		}
+		return codes, delays
+	}
+
+	firstCodes, firstDelays := codes()
+	secondCodes, secondDelays := codes()
+	assert.Contains(t, firstCodes, http.StatusServiceUnavailable)
+	assert.Equal(t, firstCodes, secondCodes)
+	assert.Equal(t, firstDelays, secondDelays)
+}
+
+func TestDynamicEndpointWithDelay(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	for _, api := range []API{
+		{Endpoint: "/first_byte", Delay: &Delay{Ms: 50}, Any: &Response{Payload: "slow"}},
+		{Endpoint: "/total", Any: &Response{Payload: "slow", Delay: &Delay{Ms: 50, Mode: "total"}}},
+		{Endpoint: "/hang", Any: &Response{Payload: "slow", Delay: &Delay{Ms: 60000}}},
+	} {
+		req := jsonRequest("POST", "/_register", api)
+		s.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	for _, endpoint := range []string{"/first_byte", "/total"} {
+		start := time.Now()
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("GET", endpoint, ""))
+		assert.True(t, time.Since(start) >= 50*time.Millisecond, endpoint)
+		assert.JSONEq(t, `"slow"`, w.Body.String(), endpoint)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/hang", "").WithContext(ctx))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Empty(t, w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/_history", ""))
+
+	var history []struct {
+		DelayMs  float64 `json:"delay_ms"`
+		Canceled bool    `json:"canceled"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.Len(t, history, 3)
+	assert.Equal(t, float64(50), history[0].DelayMs)
+	assert.Equal(t, float64(50), history[1].DelayMs)
+	assert.Equal(t, float64(60000), history[2].DelayMs)
+	assert.True(t, history[2].Canceled)
+}
+
+func TestDynamicEndpointWithFaults(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	payload := map[string]interface{}{"name": "anton", "country": "Sweden"}
+	for fault := range faults {
+		req := jsonRequest("POST", "/_register", API{
+			Endpoint: "/" + fault,
+			Any:      &Response{Payload: payload, Fault: fault},
+		})
+		s.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	for _, fault := range []string{"connection_reset", "empty_reply"} {
+		_, err := http.Get(srv.URL + "/" + fault)
+		assert.Error(t, err, fault)
+	}
+
+	resp, err := http.Get(srv.URL + "/truncated_body")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	resp, err = http.Get(srv.URL + "/malformed_json")
+	require.NoError(t, err)
+	var got interface{}
+	assert.Error(t, json.NewDecoder(resp.Body).Decode(&got))
+	resp.Body.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest("GET", srv.URL+"/headers_then_hang", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = ioutil.ReadAll(resp.Body)
+	assert.Error(t, err)
+	resp.Body.Close()
+}
+
+func TestDynamicEndpointWhenExactlyIsExhausted(t *testing.T) {
+	cases := []struct {
+		api   API
+		codes []int
+	}{
+		{API{}, []int{503, 503, 200, 404, 404}},
+		{API{OnExhausted: "then_any", Any: &Response{Code: 201}}, []int{503, 503, 200, 201, 201}},
+		{API{OnExhausted: "repeat_last"}, []int{503, 503, 200, 200, 200}},
+		{API{OnExhausted: "cycle"}, []int{503, 503, 200, 503, 503}},
+		{API{OnExhausted: "fail"}, []int{503, 503, 200, 500, 500}},
+		{API{OnExhausted: "fail", ExhaustedCode: 410}, []int{503, 503, 200, 410, 410}},
+	}
+	for _, c := range cases {
+		t.Run(c.api.OnExhausted, func(t *testing.T) {
+			s := setUp()
+			w := httptest.NewRecorder()
+			defer resetEndpoints(s, w)
+
+			api := c.api
+			api.Endpoint = "/api/test"
+			api.Exactly = []Response{{Code: 503}, {Code: 503}, {Code: 200}}
+			s.ServeHTTP(w, jsonRequest("POST", "/_register", api))
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var codes []int
+			for range c.codes {
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, jsonRequest("GET", "/api/test", ""))
+				codes = append(codes, w.Code)
+			}
+			assert.Equal(t, c.codes, codes)
+		})
+	}
+}
+
+func TestDynamicEndpointSkipsExhaustedAPIs(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	present := true
+	for _, api := range []API{
+		{Endpoint: "/x", Any: &Response{Payload: "generic"}},
+		{Endpoint: "/x", Match: &Match{Query: map[string]*ValueMatcher{"v": {Present: &present}}}, Exactly: []Response{{Payload: "specific"}}},
+		{Endpoint: "/y", Exactly: []Response{{Payload: "once"}}},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", api))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	for _, want := range []string{`"specific"`, `"generic"`, `"generic"`} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("GET", "/x?v=1", ""))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, want, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/y", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/y", ""))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	var rsp struct {
+		Text       string     `json:"text"`
+		NearMisses []NearMiss `json:"near_misses"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+	assert.Contains(t, rsp.Text, exhaustedReason)
+	require.NotEmpty(t, rsp.NearMisses)
+	assert.Equal(t, "/y", rsp.NearMisses[0].Endpoint)
+	assert.Equal(t, []string{exhaustedReason}, rsp.NearMisses[0].Reasons)
+}
+
+func TestRegisterRejectsInvalidExhaustionPolicy(t *testing.T) {
+	for _, api := range []API{
+		{Endpoint: "/api/test", OnExhausted: "forever"},
+		{Endpoint: "/api/test", OnExhausted: "then_any"},
+		{Endpoint: "/api/test", OnExhausted: "cycle", ExhaustedCode: 503},
+		{Endpoint: "/api/test", OnExhausted: "fail", ExhaustedCode: 42},
+	} {
+		s := setUp()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", api))
+		assert.Equal(t, http.StatusBadRequest, w.Code, api.OnExhausted)
+	}
+}
+
+func TestDynamicEndpointWithScenario(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	for _, api := range []API{
+		{Endpoint: "/order/1", Scenario: "order", Any: &Response{Payload: map[string]interface{}{"status": "pending"}}},
+		{Endpoint: "/order/1", Scenario: "order", RequiredState: "paid", Any: &Response{Payload: map[string]interface{}{"status": "paid"}}},
+		{Endpoint: "/order/1/pay", HTTPMethod: "POST", Scenario: "order", RequiredState: ScenarioStarted, NewState: "paid", Any: &Response{}},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", api))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	status := func() string {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("GET", "/order/1", ""))
+		require.Equal(t, http.StatusOK, w.Code)
+		var rsp map[string]string
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+		return rsp["status"]
+	}
+	scenarios := func() map[string]string {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("GET", "/_scenarios", ""))
+		var rsp map[string]string
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+		return rsp
+	}
+
+	assert.Equal(t, "pending", status())
+	assert.Equal(t, map[string]string{"order": ScenarioStarted}, scenarios())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/order/1/pay", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "paid", status())
+	assert.Equal(t, map[string]string{"order": "paid"}, scenarios())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/order/1/pay", ""))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `scenario order: expected state \"started\", got \"paid\"`)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/_scenarios/reset", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "pending", status())
+
+	s.ServeHTTP(httptest.NewRecorder(), jsonRequest("POST", "/order/1/pay", ""))
+	s.ServeHTTP(httptest.NewRecorder(), jsonRequest("POST", "/_reset", ""))
+	assert.Empty(t, scenarios())
+}
+
+func TestDynamicEndpointScenarioMovesOnceResponded(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	for _, api := range []API{
+		{Endpoint: "/pay", HTTPMethod: "POST", Scenario: "order", NewState: "paid", Delay: &Delay{Ms: 60000}, Any: &Response{}},
+		{Endpoint: "/total", HTTPMethod: "POST", Scenario: "order", NewState: "paid", Any: &Response{Payload: "slow", Delay: &Delay{Ms: 60000, Mode: "total"}}},
+		{Endpoint: "/charge", HTTPMethod: "POST", Scenario: "order", NewState: "paid", ResponseCodeProbabilities: map[string]float64{"503": 100}, Any: &Response{}},
+		{Endpoint: "/reset", HTTPMethod: "POST", Scenario: "order", NewState: "paid", Any: &Response{Fault: "connection_reset"}},
+		{Endpoint: "/empty", HTTPMethod: "POST", Scenario: "order", NewState: "paid", Any: &Response{Fault: "empty_reply"}},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", api))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	for _, endpoint := range []string{"/pay", "/total"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		s.ServeHTTP(httptest.NewRecorder(), jsonRequest("POST", endpoint, "").WithContext(ctx))
+		cancel()
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/charge", ""))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	for _, endpoint := range []string{"/reset", "/empty"} {
+		_, err := http.Post(srv.URL+endpoint, "application/json", nil)
+		require.Error(t, err, endpoint)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/_scenarios", ""))
+	assert.JSONEq(t, `{"order":"started"}`, w.Body.String())
+}
+
+func TestRegisterRejectsStateWithoutScenario(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/_register", API{Endpoint: "/api/test", NewState: "done"}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDynamicEndpointWithSeed(t *testing.T) {
+	payload := map[string]interface{}{
+		"name:full_name":        "anton",
+		"email:email_address":   "anton@example.com",
+		"words:words_n,max=10":  "",
+		"tags:word,max=5":       []interface{}{"tag"},
+		"address":               map[string]interface{}{"city:city": "Stockholm", "zip:zip": "11122"},
+		"request_id":            "{{ uuid }}",
+		"score":                 "{{ random 1 1000 }}",
+		"password:password":     "",
+		"company:company":       "",
+		"paragraph:paragraph":   "",
+		"hex:hex_color":         "",
+		"ip:i_pv_4":             "",
+		"product:product_name":  "",
+		"user_name:user_name":   "",
+		"sentence:sentences_n":  "",
+		"characters:characters": "",
+	}
+	get := func(s *Server, target string, header map[string]string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", target, nil)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		s.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w.Body.String()
+	}
+	register := func(s *Server, api API) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", api))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	first, second := setUp(WithSeed(42)), setUp(WithSeed(42))
+	for _, s := range []*Server{first, second} {
+		register(s, API{Endpoint: "/api/test", Any: &Response{Payload: payload}})
+	}
+	body := get(first, "/api/test?page=1", nil)
+	assert.Equal(t, body, get(first, "/api/test?page=1", nil))
+	assert.Equal(t, body, get(second, "/api/test?page=1", nil))
+	assert.NotEqual(t, body, get(first, "/api/test?page=2", nil))
+
+	seed := int64(7)
+	unseeded := setUp()
+	register(unseeded, API{Endpoint: "/api/test", Any: &Response{Payload: payload}, Seed: &seed})
+	stubbed := get(unseeded, "/api/test", nil)
+	assert.Equal(t, stubbed, get(unseeded, "/api/test", nil))
+	assert.NotEqual(t, stubbed, get(first, "/api/test", nil))
+
+	header := map[string]string{SeedHeader: "7"}
+	assert.Equal(t, get(first, "/api/test", header), get(unseeded, "/api/test", header))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/test", nil)
+	req.Header.Set(SeedHeader, "seven")
+	first.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDynamicEndpointWithLang(t *testing.T) {
+	cyrillic := regexp.MustCompile(`^[\p{Cyrillic}\s.]+$`)
+	latin := regexp.MustCompile(`^[\p{Latin}\s.'-]+$`)
+
+	s := setUp(WithLang("ru"))
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	for _, api := range []API{
+		{Endpoint: "/ru", Any: &Response{Payload: map[string]interface{}{"name:full_name": "", "en:full_name,lang=en": ""}}},
+		{Endpoint: "/en", Lang: "en", Any: &Response{Payload: map[string]interface{}{"name:full_name": "", "ru:full_name,locale=ru": ""}}},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", api))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, endpoint := range []string{"/ru", "/en"} {
+			wg.Add(1)
+			go func(endpoint string) {
+				defer wg.Done()
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, jsonRequest("GET", endpoint, ""))
+
+				var rsp map[string]string
+				if !assert.NoError(t, json.NewDecoder(w.Body).Decode(&rsp)) {
+					return
+				}
+				if endpoint == "/ru" {
+					assert.Regexp(t, cyrillic, rsp["name"])
+					assert.Regexp(t, latin, rsp["en"])
+				} else {
+					assert.Regexp(t, latin, rsp["name"])
+					assert.Regexp(t, cyrillic, rsp["ru"])
+				}
+			}(endpoint)
+		}
+	}
+	wg.Wait()
+
+	for _, api := range []API{
+		{Endpoint: "/bad", Lang: "xx", Any: &Response{}},
+		{Endpoint: "/bad", Any: &Response{Payload: map[string]interface{}{"name:full_name,lang=xx": ""}}},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", api))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestDynamicEndpointWithSequence(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	for _, endpoint := range []string{"/orders", "/invoices"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", API{Endpoint: endpoint, Any: &Response{Payload: map[string]interface{}{"id:sequence": 0}}}))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	for i := 1; i <= 3; i++ {
+		for _, endpoint := range []string{"/orders", "/invoices"} {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, jsonRequest("GET", endpoint, ""))
+			assert.JSONEq(t, fmt.Sprintf(`{"id":%d}`, i), w.Body.String(), endpoint)
+		}
+	}
+}
+
+func TestServersAreIsolated(t *testing.T) {
+	first := setUp()
+	second := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(first, w)
+	defer resetEndpoints(second, w)
+
+	req := jsonRequest("POST", "/_register", API{Endpoint: "/api/test", Any: &Response{}})
+	first.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	first.ServeHTTP(w, jsonRequest("GET", "/api/test", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	second.ServeHTTP(w, jsonRequest("GET", "/api/test", ""))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	for _, s := range []*Server{first, second} {
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("GET", "/_history", ""))
+
+		var history []interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+		assert.Len(t, history, 1)
+	}
+}
+
+func TestSessionsAreIsolated(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	req := jsonRequest("POST", "/_register", API{Endpoint: "/api/test", Any: &Response{Payload: map[string]interface{}{"session": "header"}}})
+	req.Header.Set(SessionHeader, "first")
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req = jsonRequest("POST", "/_session/second/_register", API{Endpoint: "/api/test", Any: &Response{Payload: map[string]interface{}{"session": "path"}}})
+	s.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/api/test", ""))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	req = jsonRequest("GET", "/_session/first/api/test", "")
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"session":"header"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req = jsonRequest("GET", "/api/test", "")
+	req.Header.Set(SessionHeader, "second")
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"session":"path"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/_session/first/_history", ""))
+	var history []map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.Len(t, history, 1)
+	assert.Equal(t, "/api/test", history[0]["endpoint"])
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/_session/first/_reset", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/_session/first/api/test", ""))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/_session/second/api/test", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestSessionHandler(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/_session", ""))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var rsp struct {
+		Session string `json:"session"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+	assert.Regexp(t, `^[0-9a-f]{32}$`, rsp.Session)
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	s := setUp()
+
+	for _, id := range []string{"ci 42", "ci/42", "ci%42"} {
+		w := httptest.NewRecorder()
+		req := jsonRequest("GET", "/api/test", "")
+		req.Header.Set(SessionHeader, id)
+		s.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, id)
+	}
+
+	for _, path := range []string{"/_session/ghost/api/test", "/_session/ghost/_history", "/_session/ghost/_scenarios"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("GET", path, ""))
+	}
+	assert.Len(t, s.sessions, 1)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/_session/idle/_register", API{Endpoint: "/api/test", Any: &Response{}}))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, s.sessions, 2)
+
+	s.sessionsMu.Lock()
+	s.sessions["idle"].used = time.Now().Add(-sessionIdleTime)
+	s.sessions[""].used = time.Now().Add(-sessionIdleTime)
+	s.swept = time.Time{}
+	s.sessionsMu.Unlock()
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/api/test", ""))
+	assert.Len(t, s.sessions, 1)
+	assert.Contains(t, s.sessions, "")
+}
 
-	return NewServer()
+func setUp(opts ...Option) *Server {
+	return NewServer(opts...)
 }
 
 func registerPayload(t *testing.T, fixtureFile string) map[string]interface{} {
@@ -2,6 +2,8 @@ package apidemicclient
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,55 +11,186 @@ import (
 )
 
 type API struct {
-	Endpoint   string     `json:"endpoint"`
-	HTTPMethod string     `json:"http_method"`
-	Any        *Response  `json:"any,omitempty"`
-	Exactly    []Response `json:"exactly,omitempty"`
+	Endpoint      string     `json:"endpoint"`
+	EndpointRegex string     `json:"endpoint_regex,omitempty"`
+	HTTPMethod    string     `json:"http_method"`
+	Match         *Match     `json:"match,omitempty"`
+	Any           *Response  `json:"any,omitempty"`
+	Exactly       []Response `json:"exactly,omitempty"`
+
+	ResponseCodeProbabilities map[string]float64     `json:"response_code_probabilities,omitempty"`
+	ResponseCodePayloads      map[string]interface{} `json:"response_code_payloads,omitempty"`
+	Seed                      *int64                 `json:"seed,omitempty"`
+	Delay                     *Delay                 `json:"delay,omitempty"`
+	OnExhausted               string                 `json:"on_exhausted,omitempty"`
+	ExhaustedCode             int                    `json:"exhausted_code,omitempty"`
+	Scenario                  string                 `json:"scenario,omitempty"`
+	RequiredState             string                 `json:"required_state,omitempty"`
+	NewState                  string                 `json:"new_state,omitempty"`
+	Lang                      string                 `json:"lang,omitempty"`
+}
+
+type Resource struct {
+	Resource string      `json:"resource"`
+	IDField  string      `json:"id_field,omitempty"`
+	Payload  interface{} `json:"payload,omitempty"`
+	Count    int         `json:"count,omitempty"`
+}
+
+type Delay struct {
+	Distribution string  `json:"distribution,omitempty"`
+	Ms           float64 `json:"ms,omitempty"`
+	MinMs        float64 `json:"min_ms,omitempty"`
+	MaxMs        float64 `json:"max_ms,omitempty"`
+	MeanMs       float64 `json:"mean_ms,omitempty"`
+	StdDevMs     float64 `json:"stddev_ms,omitempty"`
+	MedianMs     float64 `json:"median_ms,omitempty"`
+	Sigma        float64 `json:"sigma,omitempty"`
+	Mode         string  `json:"mode,omitempty"`
+}
+
+type Match struct {
+	Query   map[string]*ValueMatcher `json:"query,omitempty"`
+	Headers map[string]*ValueMatcher `json:"headers,omitempty"`
+	Body    *BodyMatcher             `json:"body,omitempty"`
+}
+
+type ValueMatcher struct {
+	Equals  *string `json:"equals,omitempty"`
+	Regex   string  `json:"regex,omitempty"`
+	Present *bool   `json:"present,omitempty"`
+}
+
+type BodyMatcher struct {
+	Subset interface{}    `json:"subset,omitempty"`
+	Paths  []*PathMatcher `json:"paths,omitempty"`
+}
+
+type PathMatcher struct {
+	Path   string      `json:"path"`
+	Equals interface{} `json:"equals,omitempty"`
+	Regex  string      `json:"regex,omitempty"`
+	Exists *bool       `json:"exists,omitempty"`
 }
 
 type Response struct {
-	Code    int         `json:"code"`
-	Payload interface{} `json:"payload"`
+	Code        int                 `json:"code"`
+	Payload     interface{}         `json:"payload"`
+	Raw         bool                `json:"raw,omitempty"`
+	Headers     map[string][]string `json:"headers,omitempty"`
+	Cookies     []Cookie            `json:"cookies,omitempty"`
+	Body        string              `json:"body,omitempty"`
+	BodyBase64  string              `json:"body_base64,omitempty"`
+	BodyFile    string              `json:"body_file,omitempty"`
+	ContentType string              `json:"content_type,omitempty"`
+	Delay       *Delay              `json:"delay,omitempty"`
+	Fault       string              `json:"fault,omitempty"`
+	Pagination  *Pagination         `json:"pagination,omitempty"`
+}
+
+type Pagination struct {
+	Style      string `json:"style,omitempty"`
+	Total      int    `json:"total"`
+	PerPage    int    `json:"per_page,omitempty"`
+	MaxPerPage int    `json:"max_per_page,omitempty"`
+	Envelope   bool   `json:"envelope,omitempty"`
+}
+
+type Cookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	MaxAge   int        `json:"max_age,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+	HTTPOnly bool       `json:"http_only,omitempty"`
+	SameSite string     `json:"same_site,omitempty"`
 }
 
 type Client struct {
 	HTTPHost string
 	host     string
 	port     int
+	session  string
 	http     *http.Client
 }
 
-type HistoryEntry struct {
-	Endpoint       string                 `json:"endpoint"`
-	Body           string                 `json:"body"`
-	Headers        map[string][]string    `json:"headers"`
-	ResponseStatus int                    `json:"response_status"`
-	ResponseBody   map[string]interface{} `json:"response_body"`
+// Option configures a Client.
+type Option func(*Client)
+
+// WithSession binds the client to the session id, made of letters, digits, '_', '.' and '-'.
+// Endpoints registered through the client, its history and reset only concern that session.
+func WithSession(id string) Option {
+	return func(c *Client) {
+		c.session = id
+	}
 }
 
-func New(host string, port int) *Client {
-	return &Client{
-		host:     host,
-		port:     port,
-		HTTPHost: fmt.Sprintf("http://%s:%d", host, port),
-		http:     &http.Client{Timeout: time.Second * 2},
+// WithNewSession binds the client to a fresh session, the server creates it on the first
+// registration. The requests made in the session before then are not kept in its history.
+func WithNewSession() Option {
+	return func(c *Client) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		c.session = hex.EncodeToString(b)
 	}
 }
 
-func NewAndReset(host string, port int) *Client {
+type HistoryEntry struct {
+	Endpoint       string              `json:"endpoint"`
+	Body           string              `json:"body"`
+	Headers        map[string][]string `json:"headers"`
+	Params         map[string]string   `json:"params"`
+	ResponseStatus int                 `json:"response_status"`
+	ResponseBranch string              `json:"response_branch"`
+	DelayMs        float64             `json:"delay_ms"`
+	Canceled       bool                `json:"canceled"`
+	Fault          string              `json:"fault"`
+	ResponseBody   interface{}         `json:"response_body"`
+	NearMisses     []NearMiss          `json:"near_misses"`
+}
+
+type NearMiss struct {
+	Endpoint   string   `json:"endpoint"`
+	HTTPMethod string   `json:"http_method"`
+	Reasons    []string `json:"reasons"`
+}
+
+func New(host string, port int, opts ...Option) *Client {
 	c := &Client{
 		host:     host,
 		port:     port,
 		HTTPHost: fmt.Sprintf("http://%s:%d", host, port),
 		http:     &http.Client{Timeout: time.Second * 2},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+func NewAndReset(host string, port int, opts ...Option) *Client {
+	c := New(host, port, opts...)
 
 	c.MustReset()
 
 	return c
 }
 
+// Session returns the id of the session the client is bound to, empty for the default one.
+func (c *Client) Session() string {
+	return c.session
+}
+
+// URL returns the URL of endpoint, within the session of the client if any.
 func (c *Client) URL(endpoint string) string {
+	if c.session != "" {
+		return fmt.Sprintf("http://%s:%d/_session/%s%s", c.host, c.port, c.session, endpoint)
+	}
 	return fmt.Sprintf("http://%s:%d%s", c.host, c.port, endpoint)
 }
 
@@ -81,7 +214,7 @@ func (c *Client) Register(api API) error {
 
 	req, err := http.NewRequest(
 		"POST",
-		fmt.Sprintf("http://%s:%d/_register", c.host, c.port),
+		c.URL("/_register"),
 		bytes.NewBuffer(data),
 	)
 	if err != nil {
@@ -101,6 +234,41 @@ func (c *Client) Register(api API) error {
 	return nil
 }
 
+// RegisterResource registers an in-memory REST resource.
+func (c *Client) RegisterResource(res Resource) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(
+		"POST",
+		c.URL("/_resource"),
+		bytes.NewBuffer(data),
+	)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status not OK, got %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (c *Client) MustRegisterResource(res Resource) {
+	if err := c.RegisterResource(res); err != nil {
+		panic(err)
+	}
+}
+
 func (c *Client) MustRegisterAny(endpoint, httpMethod string, response interface{}, responseStatus int) {
 	if err := c.RegisterAny(endpoint, httpMethod, response, responseStatus); err != nil {
 		panic(err)
@@ -114,9 +282,28 @@ func (c *Client) MustRegister(api API) {
 }
 
 func (c *Client) History() ([]HistoryEntry, error) {
+	return c.history("/_history")
+}
+
+// Unmatched returns the history entries of the requests which did not match any registered
+// endpoint. Every entry lists the endpoints which came closest and why they did not match.
+func (c *Client) Unmatched() ([]HistoryEntry, error) {
+	return c.history("/_unmatched")
+}
+
+func (c *Client) MustUnmatched() []HistoryEntry {
+	unmatched, err := c.Unmatched()
+	if err != nil {
+		panic(err)
+	}
+
+	return unmatched
+}
+
+func (c *Client) history(endpoint string) ([]HistoryEntry, error) {
 	req, err := http.NewRequest(
 		"POST",
-		fmt.Sprintf("http://%s:%d/_history", c.host, c.port),
+		c.URL(endpoint),
 		http.NoBody,
 	)
 	if err != nil {
@@ -179,7 +366,7 @@ func (c *Client) MustHistoryFor(endpoint string) []HistoryEntry {
 func (c *Client) Reset() error {
 	req, err := http.NewRequest(
 		"POST",
-		fmt.Sprintf("http://%s:%d/_reset", c.host, c.port),
+		c.URL("/_reset"),
 		http.NoBody,
 	)
 	if err != nil {
@@ -207,3 +394,74 @@ func (c *Client) MustReset() {
 		panic(err)
 	}
 }
+
+// Scenarios returns the current state of every scenario.
+func (c *Client) Scenarios() (map[string]string, error) {
+	req, err := http.NewRequest(
+		"GET",
+		c.URL("/_scenarios"),
+		http.NoBody,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("response status not OK, got %d", resp.StatusCode)
+	}
+
+	scenarios := make(map[string]string)
+	if err := json.NewDecoder(resp.Body).Decode(&scenarios); err != nil {
+		return nil, err
+	}
+
+	return scenarios, nil
+}
+
+func (c *Client) MustScenarios() map[string]string {
+	scenarios, err := c.Scenarios()
+	if err != nil {
+		panic(err)
+	}
+
+	return scenarios
+}
+
+// ResetScenarios moves every scenario back to its started state, the registered endpoints
+// and the history are kept.
+func (c *Client) ResetScenarios() error {
+	req, err := http.NewRequest(
+		"POST",
+		c.URL("/_scenarios/reset"),
+		http.NoBody,
+	)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	if err := resp.Body.Close(); err != nil {
+		return fmt.Errorf("close body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response status not OK, got %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (c *Client) MustResetScenarios() {
+	if err := c.ResetScenarios(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,49 @@
+package apidemicclient
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/makasim/apidemic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T) (*Client, func()) {
+	srv := httptest.NewServer(apidemic.NewServer())
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return New(host, p), srv.Close
+}
+
+func TestHistoryWithRawBody(t *testing.T) {
+	c, closeServer := newTestClient(t)
+	defer closeServer()
+
+	c.MustRegister(API{Endpoint: "/text", HTTPMethod: "GET", Any: &Response{Body: "plain text", ContentType: "text/plain"}})
+	c.MustRegister(API{Endpoint: "/list", HTTPMethod: "GET", Any: &Response{Payload: []interface{}{"a", "b"}}})
+	for _, endpoint := range []string{"/text", "/list", "/missing"} {
+		resp, err := http.Get(c.URL(endpoint))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	history, err := c.History()
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, "plain text", history[0].ResponseBody)
+	assert.Equal(t, []interface{}{"a", "b"}, history[1].ResponseBody)
+
+	entries, err := c.HistoryFor("/text")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	unmatched, err := c.Unmatched()
+	require.NoError(t, err)
+	assert.Len(t, unmatched, 1)
+}
@@ -0,0 +1,109 @@
+package apidemic
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// hasRawBody reports whether the response is served as is rather than encoded to JSON.
+func (rsp Response) hasRawBody() bool {
+	return rsp.Body != "" || rsp.BodyBase64 != "" || rsp.BodyFile != ""
+}
+
+func (rsp *Response) loadRawBody(fixturesDir string, tmpls templates) error {
+	set := 0
+	for _, ok := range []bool{rsp.Payload != nil, rsp.Body != "", rsp.BodyBase64 != "", rsp.BodyFile != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("apidemic: only one of payload, body, body_base64 or body_file can be set")
+	}
+
+	if rsp.BodyBase64 != "" {
+		if _, err := base64.StdEncoding.DecodeString(rsp.BodyBase64); err != nil {
+			return fmt.Errorf("apidemic: bad body_base64: %s", err)
+		}
+	}
+	if rsp.BodyFile != "" {
+		info, err := os.Stat(fixturePath(fixturesDir, rsp.BodyFile))
+		if err != nil {
+			return fmt.Errorf("apidemic: bad body_file: %s", err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("apidemic: bad body_file: %s is not a file", rsp.BodyFile)
+		}
+	}
+	if rsp.Body != "" && !rsp.Raw {
+		return tmpls.parse(rsp.Body)
+	}
+	return nil
+}
+
+// rawBody returns the body of the response for the request described by data.
+func (rsp Response) rawBody(fixturesDir string, data *templateData) ([]byte, error) {
+	switch {
+	case rsp.BodyBase64 != "":
+		return base64.StdEncoding.DecodeString(rsp.BodyBase64)
+	case rsp.BodyFile != "":
+		return ioutil.ReadFile(fixturePath(fixturesDir, rsp.BodyFile))
+	case rsp.Raw:
+		return []byte(rsp.Body), nil
+	}
+
+	body, err := renderTemplate(rsp.Body, data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(body), nil
+}
+
+// contentType is the Content-Type of the raw body when no header sets it.
+func (rsp Response) contentType() string {
+	if rsp.ContentType != "" {
+		return rsp.ContentType
+	}
+	if rsp.BodyFile != "" {
+		if typ := mime.TypeByExtension(filepath.Ext(rsp.BodyFile)); typ != "" {
+			return typ
+		}
+	}
+	if rsp.BodyBase64 != "" || rsp.BodyFile != "" {
+		return "application/octet-stream"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// fixturePath returns the path of name in dir, name cannot point outside of it.
+func fixturePath(dir, name string) string {
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// historyBody is how a raw body is recorded in the history, binary bodies are base64 encoded.
+func historyBody(body []byte) string {
+	if utf8.Valid(body) {
+		return string(body)
+	}
+	return base64.StdEncoding.EncodeToString(body)
+}
+
+// RenderRaw helper for rendering a response body as is. The Content-Type header must be set
+// beforehand.
+func RenderRaw(w http.ResponseWriter, code int, body []byte) {
+	w.WriteHeader(code)
+	if _, err := w.Write(body); err != nil {
+		log.Printf("response: body write failed: %s", err)
+	}
+
+	log.Printf("response: %d\nContent-Type: %s\n%d bytes\n\n", code, w.Header().Get("Content-Type"), len(body))
+}
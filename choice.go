@@ -0,0 +1,98 @@
+package apidemic
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// choiceSpec is how a value is picked by the one_of tag, among values, each with its weight.
+// Without weights the values are equally likely.
+//
+//	Example "status:one_of,values=active|pending|closed,weights=6|3|1"
+type choiceSpec struct {
+	values  []string
+	weights []float64
+	total   float64
+}
+
+// choiceSpec reads the options of the one_of tag.
+func (t Tags) choiceSpec() (choiceSpec, error) {
+	values, ok := t.List("values")
+	if !ok || len(values) == 0 || len(values) == 1 && values[0] == "" {
+		return choiceSpec{}, errors.New("values are missing, expected values like a|b|c")
+	}
+	spec := choiceSpec{values: values, weights: make([]float64, len(values))}
+	weights, ok := t.List("weights")
+	if !ok {
+		for i := range spec.weights {
+			spec.weights[i] = 1
+		}
+		spec.total = float64(len(values))
+		return spec, nil
+	}
+	if len(weights) != len(values) {
+		return choiceSpec{}, fmt.Errorf("got %d weights for %d values", len(weights), len(values))
+	}
+	for i, w := range weights {
+		v, err := strconv.ParseFloat(w, 64)
+		if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+			return choiceSpec{}, fmt.Errorf("bad weight %q", w)
+		}
+		spec.weights[i] = v
+		spec.total += v
+	}
+	if spec.total <= 0 {
+		return choiceSpec{}, errors.New("weights cannot all be 0")
+	}
+	return spec, nil
+}
+
+// pick picks one of the values according to their weights.
+func (spec choiceSpec) pick(g *generator) string {
+	x := g.rand.Float64() * spec.total
+	for i, w := range spec.weights {
+		if x < w {
+			return spec.values[i]
+		}
+		x -= w
+	}
+	// Rounding may leave x at the total, the last value with a weight is picked then.
+	for i := len(spec.weights) - 1; i > 0; i-- {
+		if spec.weights[i] > 0 {
+			return spec.values[i]
+		}
+	}
+	return spec.values[0]
+}
+
+// genChoice generates the value of a one_of tag for the field holding data. The value is a
+// number when data is a number and the picked value is one, like with
+// "priority:one_of,values=1|2|3": 0.
+func genChoice(g *generator, spec choiceSpec, data interface{}) interface{} {
+	picked := spec.pick(g)
+	if _, ok := data.(float64); ok {
+		if f, err := strconv.ParseFloat(picked, 64); err == nil {
+			return f
+		}
+	}
+	return picked
+}
+
+// probability reads the probability option of the bool tag, 0.5 unless set.
+func (t Tags) probability() (float64, error) {
+	if _, ok := t.Get("probability"); !ok {
+		return 0.5, nil
+	}
+	p, err := t.Float("probability")
+	if err != nil || !(p >= 0 && p <= 1) {
+		return 0, fmt.Errorf("bad probability %q, expected a number between 0 and 1", t["probability"])
+	}
+	return p, nil
+}
+
+// genBool generates the value of a bool tag, which is true with probability p.
+func genBool(g *generator, p float64) interface{} {
+	return g.rand.Float64() < p
+}
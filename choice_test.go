@@ -0,0 +1,66 @@
+package apidemic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenChoice(t *testing.T) {
+	g := newGenerator(nil)
+
+	v := NewValue("")
+	v.Tags.Load("one_of,values=active|pending|closed,weights=8|2|0")
+	require.NoError(t, v.Tags.check())
+	counts := make(map[interface{}]int)
+	for i := 0; i < 1000; i++ {
+		counts[genFakeData(g, &v)]++
+	}
+	assert.Len(t, counts, 2)
+	assert.InDelta(t, 800, counts["active"], 100)
+	assert.InDelta(t, 200, counts["pending"], 100)
+
+	v = NewValue(0.0)
+	v.Tags.Load("one_of,values=1|2|'three, or more'")
+	require.NoError(t, v.Tags.check())
+	for i := 0; i < 100; i++ {
+		assert.Contains(t, []interface{}{1.0, 2.0, "three, or more"}, genFakeData(g, &v))
+	}
+}
+
+func TestGenBool(t *testing.T) {
+	g := newGenerator(nil)
+	value, err := loadValue(map[string]interface{}{
+		"always:bool,probability=1": false,
+		"never:bool,probability=0":  true,
+		"maybe:bool":                false,
+	})
+	require.NoError(t, err)
+
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 100; i++ {
+		out := value.generate(g).(map[string]interface{})
+		assert.Equal(t, true, out["always"])
+		assert.Equal(t, false, out["never"])
+		seen[out["maybe"]] = true
+	}
+	assert.Len(t, seen, 2)
+}
+
+func TestChoiceRejectsBadOptions(t *testing.T) {
+	for _, src := range []string{
+		"one_of",
+		"one_of,values=",
+		"one_of,values=a|b,weights=1",
+		"one_of,values=a|b,weights=1|x",
+		"one_of,values=a|b,weights=0|0",
+		"one_of,values='a|b",
+		"bool,probability=2",
+		"bool,probability=maybe",
+	} {
+		tags := make(Tags)
+		tags.Load(src)
+		assert.Error(t, tags.check(), src)
+	}
+}
@@ -12,7 +12,19 @@ import (
 
 func server(ctx *cli.Context) {
 	port := ctx.Int("port")
-	s := apidemic.NewServer()
+	opts := []apidemic.Option{
+		apidemic.WithFixturesDir(ctx.String("fixtures")),
+	}
+	if ctx.IsSet("seed") {
+		opts = append(opts, apidemic.WithSeed(ctx.Int64("seed")))
+	}
+	if lang := ctx.String("lang"); lang != "" {
+		if err := apidemic.CheckLang(lang); err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, apidemic.WithLang(lang))
+	}
+	s := apidemic.NewServer(opts...)
 
 	log.Println("starting server on port :", port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), s))
@@ -23,7 +35,7 @@ func main() {
 	app.Name = "apidemic"
 	app.Usage = "Fake JSON API Responses"
 	app.Authors = []cli.Author{
-		{"Geofrey Ernest", "geofreyernest@live.com"},
+		{Name: "Geofrey Ernest", Email: "geofreyernest@live.com"},
 	}
 	app.Version = apidemic.Version
 	app.Commands = []cli.Command{
@@ -38,9 +50,26 @@ func main() {
 					Usage:  "HTTP port to run",
 					Value:  3000,
 					EnvVar: "PORT",
-						},
-					},
 				},
+				cli.StringFlag{
+					Name:   "fixtures",
+					Usage:  "directory the body_file of the responses is looked up in",
+					Value:  ".",
+					EnvVar: "FIXTURES_DIR",
+				},
+				cli.Int64Flag{
+					Name:   "seed",
+					Usage:  "seed making the fake data deterministic",
+					EnvVar: "SEED",
+				},
+				cli.StringFlag{
+					Name:   "lang",
+					Usage:  "default language of the fake data, en or ru",
+					Value:  "en",
+					EnvVar: "FAKE_LANG",
+				},
+			},
+		},
 	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,189 @@
+package apidemic
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFormats are the named formats of the date and time tags, any other format is a Go
+// layout like "2006-01-02 15:04".
+var timeFormats = map[string]string{
+	"rfc3339":      time.RFC3339,
+	"rfc3339_nano": time.RFC3339Nano,
+	"rfc1123":      time.RFC1123,
+	"rfc1123z":     time.RFC1123Z,
+	"rfc822":       time.RFC822,
+	"rfc822z":      time.RFC822Z,
+	"ansic":        time.ANSIC,
+	"kitchen":      time.Kitchen,
+}
+
+// relativeTimeRegexp matches the times relative to now, like -30d or +2h.
+var relativeTimeRegexp = regexp.MustCompile(`^([+-]\d+)(s|m|h|d|w|y)$`)
+
+// timeSpec is how a time is generated, from the options of the date, datetime, time, unix
+// and unix_ms tags. Times are picked between from and to, which are either absolute like
+// 2024-01-01 or 2024-01-01T12:00:00Z, relative to now like -30d or +2h, or now. Relative
+// bounds are relative to the now of the generator, which is fixed for seeded data. The time
+// is then formatted with format in the location tz.
+//
+//	Example "created_at:datetime,from=-30d,to=now,tz=Europe/Stockholm"
+type timeSpec struct {
+	from, to string
+	format   string
+	location *time.Location
+}
+
+// timeSpec reads the options of the time tag typ.
+func (t Tags) timeSpec(typ string) (timeSpec, error) {
+	spec := timeSpec{from: "-365d", to: "now", location: time.UTC}
+	switch typ {
+	case fieldTags.Date:
+		spec.format = "2006-01-02"
+	case fieldTags.DateTime:
+		spec.format = time.RFC3339
+	case fieldTags.Time:
+		spec.format = "15:04:05"
+	}
+	if from, ok := t.Get("from"); ok {
+		spec.from = from
+	}
+	if to, ok := t.Get("to"); ok {
+		spec.to = to
+	}
+	if format, ok := t.Get("format"); ok {
+		if typ == fieldTags.Unix || typ == fieldTags.UnixMs {
+			return timeSpec{}, fmt.Errorf("format is not used with %s", typ)
+		}
+		spec.format = format
+		if named, ok := timeFormats[strings.ToLower(format)]; ok {
+			spec.format = named
+		}
+	}
+	tz, ok := t.Get("tz")
+	if !ok {
+		tz, ok = t.Get("timezone")
+	}
+	if ok {
+		location, err := time.LoadLocation(tz)
+		if err != nil {
+			return timeSpec{}, fmt.Errorf("bad tz %q", tz)
+		}
+		spec.location = location
+	}
+
+	now := time.Now()
+	from, err := parseTimeBound(spec.from, now)
+	if err != nil {
+		return timeSpec{}, fmt.Errorf("bad from %q", spec.from)
+	}
+	to, err := parseTimeBound(spec.to, now)
+	if err != nil {
+		return timeSpec{}, fmt.Errorf("bad to %q", spec.to)
+	}
+	if to.Before(from) {
+		return timeSpec{}, errors.New("to must not be before from")
+	}
+	if to.Sub(from) == math.MaxInt64 {
+		return timeSpec{}, errors.New("from and to are too far apart")
+	}
+	return spec, nil
+}
+
+// parseTimeBound parses the from or to option of a time tag.
+func parseTimeBound(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+	if m := relativeTimeRegexp.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch m[2] {
+		case "s":
+			return now.Add(time.Duration(n) * time.Second), nil
+		case "m":
+			return now.Add(time.Duration(n) * time.Minute), nil
+		case "h":
+			return now.Add(time.Duration(n) * time.Hour), nil
+		case "d":
+			return now.AddDate(0, 0, n), nil
+		case "w":
+			return now.AddDate(0, 0, 7*n), nil
+		case "y":
+			return now.AddDate(n, 0, 0), nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// genTime generates the value of a date, datetime, time, unix or unix_ms tag.
+func genTime(g *generator, typ string, spec timeSpec) interface{} {
+	from, _ := parseTimeBound(spec.from, g.now)
+	to, _ := parseTimeBound(spec.to, g.now)
+
+	picked := from
+	if d := to.Sub(from); d > 0 {
+		picked = from.Add(time.Duration(g.rand.Int63n(int64(d))))
+	}
+	switch typ {
+	case fieldTags.Unix:
+		return picked.Unix()
+	case fieldTags.UnixMs:
+		return picked.UnixNano() / int64(time.Millisecond)
+	}
+	return picked.In(spec.location).Format(spec.format)
+}
+
+// yearSpec is the range the year tag picks years in, from and to included. When current is
+// set to is the year of the now of the generator.
+type yearSpec struct {
+	from, to int
+	current  bool
+}
+
+// yearSpec reads the from and to options of the year tag, they default to 1970 and the
+// current year.
+func (t Tags) yearSpec() (yearSpec, error) {
+	spec := yearSpec{from: 1970, to: time.Now().Year(), current: true}
+	if _, ok := t.Get("from"); ok {
+		v, err := t.Int("from")
+		if err != nil {
+			return yearSpec{}, fmt.Errorf("bad from %q", t["from"])
+		}
+		spec.from = v
+	}
+	if _, ok := t.Get("to"); ok {
+		v, err := t.Int("to")
+		if err != nil {
+			return yearSpec{}, fmt.Errorf("bad to %q", t["to"])
+		}
+		spec.to = v
+		spec.current = false
+	}
+	if spec.to < spec.from {
+		return yearSpec{}, errors.New("to must not be before from")
+	}
+	return spec, nil
+}
+
+// year picks a year according to spec.
+func (spec yearSpec) year(g *generator) int {
+	to := spec.to
+	if spec.current {
+		to = g.now.Year()
+	}
+	if to < spec.from {
+		to = spec.from
+	}
+	return spec.from + g.rand.Intn(to-spec.from+1)
+}
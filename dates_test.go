@@ -0,0 +1,118 @@
+package apidemic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenTime(t *testing.T) {
+	g := newGenerator(nil)
+	cases := []struct {
+		tags  string
+		check func(t *testing.T, v interface{})
+	}{
+		{"date,from=2024-01-01,to=2024-01-31", func(t *testing.T, v interface{}) {
+			assert.Regexp(t, `^2024-01-\d\d$`, v)
+		}},
+		{"datetime,from=-1h", func(t *testing.T, v interface{}) {
+			tm, err := time.Parse(time.RFC3339, v.(string))
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now(), tm, time.Hour+time.Second)
+		}},
+		{"datetime,from=2024-06-01T00:00:00Z,to=2024-06-01T00:00:00Z,tz=Asia/Tokyo", func(t *testing.T, v interface{}) {
+			assert.Equal(t, "2024-06-01T09:00:00+09:00", v)
+		}},
+		{"time,format=kitchen", func(t *testing.T, v interface{}) {
+			assert.Regexp(t, `^\d{1,2}:\d\d(AM|PM)$`, v)
+		}},
+		{"date,format=02/01/2006,from=2020-01-01,to=2020-12-31", func(t *testing.T, v interface{}) {
+			assert.Regexp(t, `^\d\d/\d\d/2020$`, v)
+		}},
+		{"unix,from=2024-01-01,to=2024-01-02", func(t *testing.T, v interface{}) {
+			require.IsType(t, int64(0), v)
+			assert.True(t, v.(int64) >= 1704067200 && v.(int64) <= 1704153600, v)
+		}},
+		{"unix_ms,from=-1d,to=+1d", func(t *testing.T, v interface{}) {
+			ms := time.Now().UnixNano() / int64(time.Millisecond)
+			assert.InDelta(t, ms, v.(int64), float64(24*time.Hour/time.Millisecond)+1000)
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.tags, func(t *testing.T) {
+			tags := make(Tags)
+			tags.Load(c.tags)
+			require.NoError(t, tags.check())
+			typ, _ := tags.Get("type")
+			spec, err := tags.timeSpec(typ)
+			require.NoError(t, err)
+			for i := 0; i < 100; i++ {
+				c.check(t, genTime(g, typ, spec))
+			}
+		})
+	}
+}
+
+func TestSeededTimesAreRelativeToAFixedNow(t *testing.T) {
+	seed := int64(1)
+	tags := make(Tags)
+	tags.Load("unix,from=-1d,to=now")
+	spec, err := tags.timeSpec(fieldTags.Unix)
+	require.NoError(t, err)
+
+	v := genTime(newGenerator(&seed), fieldTags.Unix, spec).(int64)
+	assert.True(t, v >= seededNow.AddDate(0, 0, -1).Unix() && v <= seededNow.Unix(), v)
+	assert.Equal(t, v, genTime(newGenerator(&seed), fieldTags.Unix, spec))
+
+	tags = make(Tags)
+	tags.Load("year,from=2020")
+	year, err := tags.yearSpec()
+	require.NoError(t, err)
+	for i := int64(0); i < 20; i++ {
+		assert.True(t, year.year(newGenerator(&i)) <= seededNow.Year())
+	}
+}
+
+func TestTimeSpecRejectsBadOptions(t *testing.T) {
+	for _, src := range []string{
+		"date,from=yesterday",
+		"date,to=-3x",
+		"date,from=2024-02-01,to=2024-01-01",
+		"datetime,from=0001-01-01,to=9999-01-01",
+		"datetime,tz=Mars/Olympus",
+		"unix,format=rfc3339",
+		"year,from=2000,to=1990",
+		"year,to=soon",
+	} {
+		tags := make(Tags)
+		tags.Load(src)
+		assert.Error(t, tags.check(), src)
+	}
+}
+
+func TestLoadValueParsesTimeSpecs(t *testing.T) {
+	value, err := loadValue(map[string]interface{}{
+		"opened:date,tz=Europe/Stockholm,count=2": []interface{}{""},
+		"founded:year,from=1999,to=1999":          0.0,
+	})
+	require.NoError(t, err)
+
+	items := value.Data.(map[string]Value)["opened"].Data.([]Value)
+	require.Len(t, items, 2)
+	spec, ok := items[0].spec.(timeSpec)
+	require.True(t, ok)
+	assert.Equal(t, "Europe/Stockholm", spec.location.String())
+
+	out := value.generate(newGenerator(nil)).(map[string]interface{})
+	assert.Len(t, out["opened"], 2)
+	assert.Equal(t, 1999, out["founded"])
+}
+
+func TestSplitKeyKeepsColonsInTags(t *testing.T) {
+	name, src, ok := splitKey("opens_at:time,format=15:04")
+	require.True(t, ok)
+	assert.Equal(t, "opens_at", name)
+	assert.Equal(t, "time,format=15:04", src)
+}
@@ -0,0 +1,158 @@
+package apidemic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+var delayDistributions = []string{"fixed", "uniform", "normal", "lognormal"}
+
+// delayChunks is the number of chunks a body is split into when its writing is slowed down.
+const delayChunks = 10
+
+// Delay describes how long a response is delayed, all durations are in milliseconds. The
+// distribution is one of
+//
+//	fixed      always Ms
+//	uniform    between MinMs and MaxMs
+//	normal     around MeanMs with a standard deviation of StdDevMs
+//	lognormal  around MedianMs, Sigma being the standard deviation of its logarithm
+//
+// The normal and lognormal delays are capped to MaxMs when it is set.
+//
+// With the first_byte mode, the default, nothing is sent before the delay is over. With the
+// total mode the headers are sent right away and the body is slowly sent over the delay.
+type Delay struct {
+	Distribution string  `json:"distribution,omitempty"`
+	Ms           float64 `json:"ms,omitempty"`
+	MinMs        float64 `json:"min_ms,omitempty"`
+	MaxMs        float64 `json:"max_ms,omitempty"`
+	MeanMs       float64 `json:"mean_ms,omitempty"`
+	StdDevMs     float64 `json:"stddev_ms,omitempty"`
+	MedianMs     float64 `json:"median_ms,omitempty"`
+	Sigma        float64 `json:"sigma,omitempty"`
+	Mode         string  `json:"mode,omitempty"`
+}
+
+func (d *Delay) load() error {
+	if d == nil {
+		return nil
+	}
+	if d.Distribution == "" {
+		d.Distribution = "fixed"
+	}
+	if d.Mode == "" {
+		d.Mode = "first_byte"
+	}
+	if d.Mode != "first_byte" && d.Mode != "total" {
+		return fmt.Errorf("apidemic: bad delay mode %q", d.Mode)
+	}
+	if d.Ms < 0 || d.MinMs < 0 || d.MaxMs < 0 || d.MeanMs < 0 || d.StdDevMs < 0 || d.MedianMs < 0 || d.Sigma < 0 {
+		return errors.New("apidemic: delay cannot be negative")
+	}
+
+	switch d.Distribution {
+	case "fixed":
+	case "uniform":
+		if d.MaxMs < d.MinMs {
+			return errors.New("apidemic: delay max_ms must not be less than min_ms")
+		}
+	case "normal":
+		if d.MeanMs == 0 {
+			return errors.New("apidemic: normal delay needs mean_ms")
+		}
+	case "lognormal":
+		if d.MedianMs == 0 {
+			return errors.New("apidemic: lognormal delay needs median_ms")
+		}
+	default:
+		return fmt.Errorf("apidemic: bad delay distribution %q, expected one of %v", d.Distribution, delayDistributions)
+	}
+	return nil
+}
+
+// duration picks how long to delay a response.
+func (d *Delay) duration(state *apiState) time.Duration {
+	var ms float64
+	switch d.Distribution {
+	case "fixed":
+		ms = d.Ms
+	case "uniform":
+		ms = d.MinMs + state.float64()*(d.MaxMs-d.MinMs)
+	case "normal":
+		ms = d.MeanMs + state.normFloat64()*d.StdDevMs
+	case "lognormal":
+		ms = math.Exp(math.Log(d.MedianMs) + state.normFloat64()*d.Sigma)
+	}
+	if d.MaxMs > 0 && ms > d.MaxMs {
+		ms = d.MaxMs
+	}
+	if ms < 0 {
+		ms = 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// sleep waits for d, it returns false when ctx is done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// slowWriter spreads the writing of the body over a duration. It stops writing as soon as
+// the request context is done.
+type slowWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	duration time.Duration
+	canceled bool
+}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	size := (len(b) + delayChunks - 1) / delayChunks
+	if size == 0 {
+		return 0, nil
+	}
+
+	written := 0
+	pause := w.duration / time.Duration((len(b)+size-1)/size)
+	for written < len(b) {
+		end := written + size
+		if end > len(b) {
+			end = len(b)
+		}
+		n, err := w.ResponseWriter.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		if !sleep(w.ctx, pause) {
+			w.canceled = true
+			return written, w.ctx.Err()
+		}
+	}
+	return written, nil
+}
+
+func (w *slowWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
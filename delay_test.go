@@ -0,0 +1,52 @@
+package apidemic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelayDuration(t *testing.T) {
+	seed := int64(1)
+	state := newAPIState(&seed)
+
+	sample := []struct {
+		delay    Delay
+		min, max time.Duration
+	}{
+		{Delay{Ms: 150}, 150 * time.Millisecond, 150 * time.Millisecond},
+		{Delay{Distribution: "uniform", MinMs: 10, MaxMs: 20}, 10 * time.Millisecond, 20 * time.Millisecond},
+		{Delay{Distribution: "normal", MeanMs: 100, StdDevMs: 500, MaxMs: 300}, 0, 300 * time.Millisecond},
+		{Delay{Distribution: "lognormal", MedianMs: 100, Sigma: 2, MaxMs: 1000}, 0, time.Second},
+	}
+	for _, v := range sample {
+		require.NoError(t, v.delay.load())
+		for i := 0; i < 100; i++ {
+			d := v.delay.duration(state)
+			assert.True(t, d >= v.min && d <= v.max, "%s delay %s out of range", v.delay.Distribution, d)
+		}
+	}
+
+	for _, d := range []Delay{
+		{Distribution: "poisson"},
+		{Distribution: "uniform", MinMs: 20, MaxMs: 10},
+		{Distribution: "normal"},
+		{Ms: 10, Mode: "last_byte"},
+		{Ms: -1},
+	} {
+		assert.Error(t, d.load(), "%#v", d)
+	}
+}
+
+func TestSleepHonoursContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.False(t, sleep(ctx, time.Minute))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.True(t, sleep(context.Background(), time.Millisecond))
+}
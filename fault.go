@@ -0,0 +1,138 @@
+package apidemic
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// codeProbability is the chance, in percent, that an API responds with code.
+type codeProbability struct {
+	code        int
+	probability float64
+	response    Response
+}
+
+// loadCodeProbabilities validates ResponseCodeProbabilities and prepares the responses
+// rendered for every code.
+func (a *API) loadCodeProbabilities(fixturesDir string) error {
+	a.codes = nil
+	total := 0.0
+	for key, p := range a.ResponseCodeProbabilities {
+		c, err := strconv.Atoi(key)
+		if err != nil || c < 100 || c > 599 {
+			return fmt.Errorf("apidemic: bad response code %q in response_code_probabilities", key)
+		}
+		if p < 0 {
+			return fmt.Errorf("apidemic: negative probability for response code %d", c)
+		}
+		total += p
+
+		rsp := Response{Code: c, Payload: NewResponse(http.StatusText(c)), Raw: true}
+		if payload, ok := a.ResponseCodePayloads[key]; ok {
+			rsp = Response{Code: c, Payload: payload}
+		}
+		if err := rsp.load(fixturesDir, a.state.templates); err != nil {
+			return err
+		}
+		a.codes = append(a.codes, codeProbability{code: c, probability: p, response: rsp})
+	}
+	if total > 100 {
+		return fmt.Errorf("apidemic: response_code_probabilities add up to %v%%, more than 100%%", total)
+	}
+	for key := range a.ResponseCodePayloads {
+		if _, ok := a.ResponseCodeProbabilities[key]; !ok {
+			return fmt.Errorf("apidemic: response code %q has a payload but no probability", key)
+		}
+	}
+
+	sort.Slice(a.codes, func(i, j int) bool {
+		return a.codes[i].code < a.codes[j].code
+	})
+	return nil
+}
+
+// pickCode randomly picks one of the response codes according to their probabilities. It
+// returns false when the regular response of the API should be rendered.
+func (a API) pickCode() (codeProbability, bool) {
+	if len(a.codes) == 0 {
+		return codeProbability{}, false
+	}
+
+	n := a.state.float64() * 100
+	for _, c := range a.codes {
+		if n < c.probability {
+			return c, true
+		}
+		n -= c.probability
+	}
+	return codeProbability{}, false
+}
+
+// faults describes the supported Response.Fault modes.
+var faults = map[string]string{
+	"connection_reset":  "the connection is reset without sending anything",
+	"empty_reply":       "the connection is closed without sending anything",
+	"headers_then_hang": "the headers are sent, then nothing until the client gives up",
+	"truncated_body":    "half of the body is sent, then the connection is closed",
+	"malformed_json":    "the body is sent without its last byte",
+}
+
+// renderFault breaks the response according to fault. The headers must be set beforehand. It
+// reports whether any of the response was sent, which is not the case when the connection is
+// closed right away.
+func renderFault(w http.ResponseWriter, r *http.Request, fault string, code int, body []byte) bool {
+	log.Printf("response: %d with fault %s", code, fault)
+
+	switch fault {
+	case "headers_then_hang":
+		w.WriteHeader(code)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		<-r.Context().Done()
+		return true
+	case "malformed_json":
+		if len(body) > 0 {
+			body = body[:len(body)-1]
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		RenderRaw(w, code, body)
+		return true
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		RenderJSON(w, http.StatusInternalServerError, NewResponse(fmt.Sprintf("apidemic: fault %s is not supported by the connection", fault)))
+		return false
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		log.Printf("response: hijack failed: %s", err)
+		return false
+	}
+	defer conn.Close()
+
+	switch fault {
+	case "connection_reset":
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			// Without lingering closing the connection sends a RST rather than a FIN.
+			tcp.SetLinger(0)
+		}
+	case "truncated_body":
+		header := w.Header().Clone()
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+		fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+		header.Write(buf)
+		buf.WriteString("\r\n")
+		buf.Write(body[:len(body)/2])
+		if err := buf.Flush(); err != nil {
+			log.Printf("response: body write failed: %s", err)
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package apidemic
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SeedHeader is the header setting the seed of the fake data generated for a request.
+const SeedHeader = "X-Apidemic-Seed"
+
+// seededNow is the current time of the seeded generators, so that the times relative to now
+// do not change from a request to the next.
+var seededNow = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// generator holds what the fake data generated for a request depends on. Everything random
+// is drawn from rand, so that a seeded generator always generates the same data. The times
+// relative to now are relative to now, the clock or seededNow. The data is in lang unless
+// its tags tell otherwise. The sequence tags count with sequences. The items of paginated
+// collections are drawn from generators derived from itemSeed, see item.
+type generator struct {
+	rand      *rand.Rand
+	now       time.Time
+	lang      string
+	sequences *sequences
+	itemSeed  int64
+}
+
+// newGenerator returns a generator seeded with seed, or with the current time when seed
+// is nil.
+func newGenerator(seed *int64) *generator {
+	if seed == nil {
+		now := time.Now()
+		return &generator{rand: rand.New(rand.NewSource(now.UnixNano())), now: now, sequences: newSequences(), itemSeed: now.UnixNano()}
+	}
+	return &generator{rand: rand.New(rand.NewSource(*seed)), now: seededNow, sequences: newSequences(), itemSeed: *seed}
+}
+
+// item returns the generator of the item at index i of a collection. It only depends on the
+// itemSeed of g and on i, so that an item is the same whatever the page it is served in, and
+// its sequences count as if the i items before it had been generated.
+func (g *generator) item(i int) *generator {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%d", g.itemSeed, i)
+	seed := int64(h.Sum64())
+	return &generator{
+		rand:      rand.New(rand.NewSource(seed)),
+		now:       g.now,
+		lang:      g.lang,
+		sequences: &sequences{next: make(map[string]int64), skip: int64(i)},
+		itemSeed:  seed,
+	}
+}
+
+// generatorFor returns the generator of the fake data of a request served by api, which may
+// be nil. The seed is the one of the SeedHeader of the request, else the seed of api, else
+// the seed of the server. The seed is mixed with the method, URI and body of the request, so
+// that the same request always gets the same data while different requests get different
+// data. The language is the one of api, else the one of the server. The sequences are the
+// ones of api, so that they keep counting from a request to the next. Without a seed the
+// paginated items are drawn from the itemSeed of api, so that its pages stay the same.
+func (s *Server) generatorFor(r *http.Request, body []byte, api *API) (*generator, error) {
+	var seed *int64
+	if v := r.Header.Get(SeedHeader); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("apidemic: bad %s %q", SeedHeader, v)
+		}
+		seed = &n
+	} else if api != nil && api.Seed != nil {
+		seed = api.Seed
+	} else {
+		seed = s.seed
+	}
+
+	var g *generator
+	if seed == nil {
+		g = newGenerator(nil)
+		if api != nil && api.state != nil {
+			g.itemSeed = api.state.itemSeed
+		}
+	} else {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+		h.Write(body)
+		mixed := *seed ^ int64(h.Sum64())
+		g = newGenerator(&mixed)
+
+		// Paginated items do not depend on the query, which tells the page.
+		h = fnv.New64a()
+		fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.Path)
+		g.itemSeed = *seed ^ int64(h.Sum64())
+	}
+
+	g.lang = s.lang
+	if api != nil && api.Lang != "" {
+		g.lang = api.Lang
+	}
+	if api != nil && api.state != nil {
+		g.sequences = api.state.sequences
+	}
+	return g, nil
+}
@@ -0,0 +1,222 @@
+package apidemic
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHexLength limits the number of digits of the hex tag.
+const maxHexLength = 1024
+
+// maxSlugWords limits the number of words of the slug tag.
+const maxSlugWords = 20
+
+// crockford is the base32 alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// sequences holds the counters of the sequence tags. Fields with the same sequence tags
+// share their counter. Every counter skips its first skip values.
+type sequences struct {
+	mu   sync.Mutex
+	next map[string]int64
+	skip int64
+}
+
+func newSequences() *sequences {
+	return &sequences{next: make(map[string]int64)}
+}
+
+// nextValue returns the next value of the counter of key, counting from start by step.
+func (s *sequences) nextValue(key string, start, step int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.next[key]
+	if !ok {
+		v = start + s.skip*step
+	}
+	s.next[key] = v + step
+	return v
+}
+
+// sequenceSpec is how the sequence tag counts, from start by step with the counter of key.
+type sequenceSpec struct {
+	start, step int64
+	key         string
+}
+
+// sequenceSpec reads the start and step options of the sequence tag, both default to 1.
+func (t Tags) sequenceSpec() (sequenceSpec, error) {
+	spec := sequenceSpec{start: 1, step: 1, key: t.sequenceKey()}
+	if _, ok := t.Get("start"); ok {
+		v, err := t.Int("start")
+		if err != nil {
+			return sequenceSpec{}, fmt.Errorf("bad start %q", t["start"])
+		}
+		spec.start = int64(v)
+	}
+	if _, ok := t.Get("step"); ok {
+		v, err := t.Int("step")
+		if err != nil || v <= 0 {
+			return sequenceSpec{}, fmt.Errorf("bad step %q, expected a positive integer", t["step"])
+		}
+		spec.step = int64(v)
+	}
+	return spec, nil
+}
+
+// sequenceKey identifies the counter of a sequence tag by its options.
+func (t Tags) sequenceKey() string {
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%s,", key, t[key])
+	}
+	return b.String()
+}
+
+// uuidVersion reads the version option of the uuid tag, 4 unless set.
+func (t Tags) uuidVersion() (int, error) {
+	if _, ok := t.Get("version"); !ok {
+		return 4, nil
+	}
+	v, err := t.Int("version")
+	if err != nil || (v != 4 && v != 7) {
+		return 0, fmt.Errorf("bad version %q, expected 4 or 7", t["version"])
+	}
+	return v, nil
+}
+
+// hexLength reads the length option of the hex tag, 32 unless set.
+func (t Tags) hexLength() (int, error) {
+	if _, ok := t.Get("length"); !ok {
+		return 32, nil
+	}
+	n, err := t.Int("length")
+	if err != nil || n < 1 || n > maxHexLength {
+		return 0, fmt.Errorf("bad length %q, expected a number of digits up to %d", t["length"], maxHexLength)
+	}
+	return n, nil
+}
+
+// slugWords reads the words option of the slug tag, 3 unless set.
+func (t Tags) slugWords() (int, error) {
+	if _, ok := t.Get("words"); !ok {
+		return 3, nil
+	}
+	n, err := t.Int("words")
+	if err != nil || n < 1 || n > maxSlugWords {
+		return 0, fmt.Errorf("bad words %q, expected a number of words up to %d", t["words"], maxSlugWords)
+	}
+	return n, nil
+}
+
+// idSpec reads the options of the identifier tag typ: the uuid version, the hex length, the
+// slug words or the sequenceSpec. It returns nil for the tags without options.
+func (t Tags) idSpec(typ string) (interface{}, error) {
+	switch typ {
+	case fieldTags.UUID:
+		return t.uuidVersion()
+	case fieldTags.Hex:
+		return t.hexLength()
+	case fieldTags.Slug:
+		return t.slugWords()
+	case fieldTags.Sequence:
+		return t.sequenceSpec()
+	}
+	return nil, nil
+}
+
+// genID generates the value of an identifier tag from its idSpec. The time ordered
+// identifiers, uuid version 7, ulid and object_id, embed the now of g, which is fixed for
+// seeded data.
+func genID(g *generator, typ string, spec interface{}) interface{} {
+	switch typ {
+	case fieldTags.UUID:
+		if v, _ := spec.(int); v == 7 {
+			return newUUIDv7(g.rand, g.now)
+		}
+		return newUUID(g.rand)
+	case fieldTags.ULID:
+		return newULID(g.rand, g.now)
+	case fieldTags.ObjectID:
+		b := make([]byte, 12)
+		binary.BigEndian.PutUint32(b, uint32(g.now.Unix()))
+		g.rand.Read(b[4:])
+		return hex.EncodeToString(b)
+	case fieldTags.Hex:
+		n := spec.(int)
+		b := make([]byte, (n+1)/2)
+		g.rand.Read(b)
+		return hex.EncodeToString(b)[:n]
+	case fieldTags.SHA256:
+		b := make([]byte, 32)
+		g.rand.Read(b)
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	case fieldTags.Slug:
+		return genSlug(g, spec.(int))
+	case fieldTags.Sequence:
+		seq := spec.(sequenceSpec)
+		return g.sequences.nextValue(seq.key, seq.start, seq.step)
+	}
+	return nil
+}
+
+// newUUIDv7 returns a version 7 UUID, its first 48 bits are the unix time in milliseconds.
+func newUUIDv7(r *rand.Rand, now time.Time) string {
+	b := make([]byte, 16)
+	r.Read(b)
+	ms := uint64(now.UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> uint(40-8*i))
+	}
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// newULID returns a ULID, 48 bits of unix time in milliseconds followed by 80 random bits
+// encoded in 26 characters of Crockford's base32.
+func newULID(r *rand.Rand, now time.Time) string {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, uint64(now.UnixNano()/int64(time.Millisecond))<<16)
+	r.Read(b[6:])
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+// genSlug returns n words joined by dashes, like quick-brown-fox. The words are english
+// whatever the language of the fake data, so that slugs stay URL friendly.
+func genSlug(g *generator, n int) string {
+	f := faker{rand: g.rand, lang: "en"}
+	words := make([]string, 0, n)
+	for len(words) < n {
+		word := strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, strings.ToLower(f.word()))
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, "-")
+}
@@ -0,0 +1,81 @@
+package apidemic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenID(t *testing.T) {
+	g := newGenerator(nil)
+	cases := []struct {
+		tags    string
+		pattern string
+	}{
+		{"uuid", `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{"uuid,version=7", `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{"ulid", `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`},
+		{"object_id", `^[0-9a-f]{24}$`},
+		{"hex", `^[0-9a-f]{32}$`},
+		{"hex,length=7", `^[0-9a-f]{7}$`},
+		{"sha256", `^[0-9a-f]{64}$`},
+		{"slug,words=4", `^[a-z0-9]+(-[a-z0-9]+){3}$`},
+		{"slug,lang=ru", `^[a-z0-9]+(-[a-z0-9]+){2}$`},
+	}
+	for _, c := range cases {
+		t.Run(c.tags, func(t *testing.T) {
+			v := NewValue("")
+			v.Tags.Load(c.tags)
+			require.NoError(t, v.Tags.check())
+			for i := 0; i < 20; i++ {
+				assert.Regexp(t, c.pattern, genFakeData(g, &v))
+			}
+		})
+	}
+}
+
+func TestGenerateTaggedNulls(t *testing.T) {
+	value, err := loadValue(map[string]interface{}{"id:uuid": nil, "n:sequence": nil, "none": nil})
+	require.NoError(t, err)
+	out := value.generate(newGenerator(nil)).(map[string]interface{})
+	assert.Regexp(t, `^[0-9a-f-]{36}$`, out["id"])
+	assert.Equal(t, int64(1), out["n"])
+	assert.Contains(t, out, "none")
+	assert.Nil(t, out["none"])
+}
+
+func TestTimeOrderedIDs(t *testing.T) {
+	g := newGenerator(nil)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "018f3406-9e00", newUUIDv7(g.rand, now)[:13])
+	assert.Equal(t, "01HWT0D7G0", newULID(g.rand, now)[:10])
+	assert.True(t, newULID(g.rand, now) < newULID(g.rand, now.Add(time.Millisecond)))
+}
+
+func TestSeededTimeOrderedIDs(t *testing.T) {
+	seed := int64(3)
+	for _, typ := range []string{fieldTags.ULID, fieldTags.ObjectID} {
+		first := genID(newGenerator(&seed), typ, nil)
+		time.Sleep(2 * time.Millisecond)
+		assert.Equal(t, first, genID(newGenerator(&seed), typ, nil), typ)
+	}
+	assert.Equal(t, genID(newGenerator(&seed), fieldTags.UUID, 7), genID(newGenerator(&seed), fieldTags.UUID, 7))
+	assert.Equal(t, newULID(newGenerator(&seed).rand, seededNow), genID(newGenerator(&seed), fieldTags.ULID, nil))
+}
+
+func TestSequence(t *testing.T) {
+	g := newGenerator(nil)
+	v := NewValue([]interface{}{map[string]interface{}{"id:sequence,start=10,step=5": 0.0}})
+	v.Tags.Load("list,max=3")
+	out := v.generate(g).([]interface{})
+	require.Len(t, out, 3)
+	for i, item := range out {
+		assert.Equal(t, int64(10+5*i), item.(map[string]interface{})["id"])
+	}
+
+	tags := make(Tags)
+	tags.Load("sequence,step=0")
+	assert.Error(t, tags.check())
+}
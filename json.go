@@ -3,23 +3,34 @@ package apidemic
 import (
 	"encoding/json"
 	"io"
-	"strconv"
+	"regexp/syntax"
 	"strings"
-
-	"github.com/icrowley/fake"
 )
 
 type Value struct {
 	Tags Tags
 	Data interface{}
+
+	// spec holds the parsed options of Tags, see Tags.spec. It is set by loadValue so that
+	// the tags are parsed once, not for every generated value.
+	spec interface{}
 }
 
+// Update returns v where tagged data is replaced by fake data, drawn from a generator seeded
+// with the current time.
 func (v Value) Update() Value {
+	return v.update(newGenerator(nil))
+}
+
+func (v Value) update(g *generator) Value {
 	switch v.Data.(type) {
-	case string:
-		return fakeString(&v)
+	case string, nil:
+		// Tagged nulls, like "id:uuid": null, are generated like strings.
+		return fakeString(g, &v)
 	case float64:
-		return fakeFloats(&v)
+		return fakeFloats(g, &v)
+	case bool:
+		return fakeBool(g, &v)
 	case []interface{}:
 		return fakeArray(&v)
 	case map[string]interface{}:
@@ -29,7 +40,51 @@ func (v Value) Update() Value {
 }
 
 func (v Value) MarshalJSON() ([]byte, error) {
-	return json.Marshal(v.Update().Data)
+	return json.Marshal(v.Generate())
+}
+
+// Generate returns a plain copy of the data held by v, where every tagged field
+// is replaced by freshly generated fake data. Unlike Update the result does not
+// contain any Value, so generating it again is needed to get new fake data.
+func (v Value) Generate() interface{} {
+	return v.generate(newGenerator(nil))
+}
+
+// generate is Generate drawing the fake data from g.
+func (v Value) generate(g *generator) interface{} {
+	switch data := v.update(g).Data.(type) {
+	case map[string]Value:
+		out := make(map[string]interface{}, len(data))
+		for _, key := range sortedKeys(data) {
+			switch data[key].presence(g) {
+			case fieldOmitted:
+				continue
+			case fieldNull:
+				out[key] = nil
+				continue
+			}
+			out[key] = data[key].generate(g)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(data))
+		for i, val := range data {
+			if nv, ok := val.(Value); ok {
+				out[i] = nv.generate(g)
+				continue
+			}
+			out[i] = val
+		}
+		return out
+	case []Value:
+		out := make([]interface{}, len(data))
+		for i, val := range data {
+			out[i] = val.generate(g)
+		}
+		return out
+	default:
+		return data
+	}
 }
 
 func NewValue(val interface{}) Value {
@@ -47,10 +102,10 @@ func NewObject() *Object {
 func (o *Object) Load(src map[string]interface{}) error {
 	for key, val := range src {
 		value := NewValue(val)
-		sections := strings.Split(key, ":")
-		if len(sections) == 2 {
-			key = sections[0]
-			value.Tags.Load(sections[1])
+		name, tags, ok := splitKey(key)
+		if ok {
+			key = name
+			value.Tags.Load(tags)
 		}
 		o.Set(key, value)
 	}
@@ -65,6 +120,52 @@ func (v *Object) MarshalJSON() ([]byte, error) {
 	return json.Marshal(v.Data)
 }
 
+// loadValue turns a decoded JSON payload into a Value whose tagged keys are
+// parsed, so that it is ready to generate fake data.
+func loadValue(src interface{}) (Value, error) {
+	return loadTagged(make(Tags), src), nil
+}
+
+// loadTagged returns the Value of data tagged with tags, with the specs of the tags parsed
+// and the objects and arrays of data loaded as well, holding map[string]Value and []Value.
+// The arrays are laid out like fakeArray does, so generating the Value parses no tag.
+func loadTagged(tags Tags, data interface{}) Value {
+	v := Value{Tags: tags, Data: data}
+	switch data := data.(type) {
+	case map[string]interface{}:
+		obj := make(map[string]Value, len(data))
+		for key, val := range data {
+			itemTags := make(Tags)
+			if name, src, ok := splitKey(key); ok {
+				key = name
+				itemTags.Load(src)
+			}
+			obj[key] = loadTagged(itemTags, val)
+		}
+		v.Data = obj
+	case []interface{}:
+		items := make([]Value, 0, len(data))
+		if !tags.expands() {
+			for _, item := range data {
+				items = append(items, loadTagged(make(Tags), item))
+			}
+		} else if len(data) > 0 {
+			n, itemTags, err := tags.arraySpec(len(data))
+			if err != nil {
+				return v
+			}
+			item := loadTagged(itemTags, data[0])
+			for i := 0; i < n; i++ {
+				items = append(items, item)
+			}
+		}
+		v.Data = items
+	default:
+		v.spec, _ = tags.spec()
+	}
+	return v
+}
+
 func parseJSONData(src io.Reader) (*Object, error) {
 	var in map[string]interface{}
 	err := json.NewDecoder(src).Decode(&in)
@@ -79,8 +180,8 @@ func parseJSONData(src io.Reader) (*Object, error) {
 	return o, nil
 }
 
-func fakeString(v *Value) Value {
-	return Value{Data: genFakeData(v)}
+func fakeString(g *generator, v *Value) Value {
+	return Value{Data: genFakeData(g, v)}
 }
 
 func fakeArray(v *Value) Value {
@@ -89,20 +190,24 @@ func fakeArray(v *Value) Value {
 		return *v
 	}
 	nv := *v
-	var rst []interface{}
+	rst := make([]interface{}, 0)
+	// Untagged arrays keep their items, which may hold tagged objects.
+	if !v.Tags.expands() {
+		for _, item := range arrV {
+			rst = append(rst, NewValue(item))
+		}
+		nv.Data = rst
+		return nv
+	}
 	if len(arrV) > 0 {
 		origin := arrV[0]
-		n := len(arrV)
-
-		if max, ok := v.Tags.Get("max"); ok {
-			maxV, err := strconv.Atoi(max)
-			if err != nil {
-				return *v
-			}
-			n = maxV
+		n, tags, err := v.Tags.arraySpec(len(arrV))
+		if err != nil {
+			return *v
 		}
 		for i := 0; i < n; i++ {
 			newVal := NewValue(origin)
+			newVal.Tags = tags
 			rst = append(rst, newVal)
 		}
 	}
@@ -110,8 +215,12 @@ func fakeArray(v *Value) Value {
 	return nv
 }
 
-func fakeFloats(v *Value) Value {
-	return Value{Data: genFakeData(v)}
+func fakeFloats(g *generator, v *Value) Value {
+	return Value{Data: genFakeData(g, v)}
+}
+
+func fakeBool(g *generator, v *Value) Value {
+	return Value{Data: genFakeData(g, v)}
 }
 
 func fakeObject(v *Value) Value {
@@ -120,7 +229,7 @@ func fakeObject(v *Value) Value {
 	return NewValue(obj.Data)
 }
 
-func genFakeData(v *Value) interface{} {
+func genFakeData(g *generator, v *Value) interface{} {
 	if len(v.Tags) == 0 {
 		return v.Data
 	}
@@ -129,138 +238,155 @@ func genFakeData(v *Value) interface{} {
 	if !ok {
 		return v.Data
 	}
+	spec := v.spec
+	if spec == nil {
+		var err error
+		if spec, err = v.Tags.spec(); err != nil {
+			return nil
+		}
+	}
+	f := faker{rand: g.rand, lang: g.lang}
+	if l, ok := v.Tags.lang(); ok {
+		f.lang = l
+	}
+	if f.lang == "" {
+		f.lang = "en"
+	}
+
 	switch typ {
 	case fieldTags.Brand:
-		return fake.Brand()
+		return f.company()
 	case fieldTags.Character:
-		return fake.Character()
+		return f.character()
 	case fieldTags.Characters:
-		return fieldTags.Characters
+		return f.charactersN(f.rand.Intn(5) + 1)
 	case fieldTags.CharactersN:
 		max := 5
 		if m, err := v.Tags.Int("max"); err == nil {
 			max = m
 		}
-		return fake.CharactersN(max)
+		return f.charactersN(max)
 	case fieldTags.City:
-		return fake.City()
+		return f.city()
 	case fieldTags.Color:
-		return fake.Color()
+		return f.lookup("colors", true)
 	case fieldTags.Company:
-		return fake.Company()
+		return f.company()
 	case fieldTags.Continent:
-		return fake.Continent()
+		return f.lookup("continents", true)
 	case fieldTags.Country:
-		return fake.Country()
+		return f.lookup("countries", true)
 	case fieldTags.CreditCardNum:
 		vendor, _ := v.Tags.Get("vendor")
-		fake.CreditCardNum(vendor)
+		return f.creditCardNum(vendor)
 	case fieldTags.Currency:
-		fake.Currency()
+		return f.lookup("currencies", true)
 	case fieldTags.CurrencyCode:
-		fake.CurrencyCode()
+		return f.lookup("currency_codes", true)
 	case fieldTags.Day:
-		return fake.Day()
+		return f.rand.Intn(31) + 1
 	case fieldTags.Digits:
-		return fake.Digits()
+		return f.runes(f.rand.Intn(5)+1, numeric)
 	case fieldTags.DigitsN:
 		max := 5
 		if m, err := v.Tags.Int("max"); err == nil {
 			max = m
 		}
-		return fake.DigitsN(max)
+		return f.runes(max, numeric)
 	case fieldTags.DomainName:
-		return fake.DomainName()
+		return f.domainName()
 	case fieldTags.DomainZone:
-		return fake.DomainZone()
+		return f.lookup("domain_zones", true)
 	case fieldTags.EmailAddress:
-		return fake.EmailAddress()
+		return f.userName() + "@" + f.domainName()
 	case fieldTags.EmailBody:
-		return fake.EmailBody()
+		return f.paragraphsN(f.rand.Intn(5) + 1)
 	case fieldTags.FemaleFirstName:
-		return fake.FemaleFirstName()
+		return f.firstName("female")
 	case fieldTags.FemaleFullName:
-		return fake.FemaleFullName()
+		return f.fullName("female")
 	case fieldTags.FemaleFullNameWithPrefix:
-		return fake.FemaleFullNameWithPrefix()
+		return f.fullNameWithPrefix("female")
 	case fieldTags.FemaleFullNameWithSuffix:
-		return fake.FemaleFullNameWithSuffix()
+		return f.fullNameWithSuffix("female")
 	case fieldTags.FemaleLastName:
-		return fake.FemaleLastName()
+		return f.lastName("female")
 	case fieldTags.FemaleLastNamePratronymic:
-		return fake.FemalePatronymic()
+		return f.patronymic("female")
 	case fieldTags.FirstName:
-		return fake.FirstName()
+		return f.firstName(f.gender())
 	case fieldTags.FullName:
-		return fake.FullName()
+		return f.fullName(f.gender())
 	case fieldTags.FullNameWithPrefix:
-		return fake.FullNameWithPrefix()
+		return f.fullNameWithPrefix(f.gender())
 	case fieldTags.FullNameWithSuffix:
-		return fake.FullNameWithSuffix()
+		return f.fullNameWithSuffix(f.gender())
 	case fieldTags.Gender:
-		return fake.Gender()
+		return f.lookup("genders", true)
 	case fieldTags.GenderAbrev:
-		return fake.GenderAbbrev()
+		return f.genderAbbrev()
 	case fieldTags.HexColor:
-		return fake.HexColor()
+		return f.runes(6, hexDigits)
 	case fieldTags.HexColorShort:
-		return fake.HexColorShort()
+		return f.runes(3, hexDigits)
 	case fieldTags.IPv4:
-		return fake.IPv4()
+		return f.ipv4()
 	case fieldTags.Industry:
-		return fake.Industry()
+		return f.lookup("industries", true)
 	case fieldTags.JobTitle:
-		return fake.JobTitle()
+		return strings.Replace(f.lookup("jobs", true), "#{N}", f.lookup("jobs_suffixes", false), 1)
 	case fieldTags.Language:
-		return fake.Language()
+		return f.lookup("languages", true)
 	case fieldTags.LastName:
-		return fake.LastName()
+		return f.lastName(f.gender())
 	case fieldTags.LatitudeDegrees:
-		return fake.LatitudeDegrees()
+		return f.rand.Intn(180) - 90
 	case fieldTags.LatitudeDirection:
-		return fake.LatitudeDirection()
+		return []string{"N", "S"}[f.rand.Intn(2)]
 	case fieldTags.LatitudeMinutes:
-		return fake.LatitudeMinutes()
+		return f.rand.Intn(60)
 	case fieldTags.LatitudeSeconds:
-		return fake.LatitudeSeconds()
+		return f.rand.Intn(60)
 	case fieldTags.Latitude:
-		return fake.Latitude()
+		return f.rand.Float32()*180 - 90
+	case fieldTags.Longitude:
+		return f.rand.Float32()*360 - 180
 	case fieldTags.LongitudeDegrees:
-		return fake.LongitudeDegrees()
+		return f.rand.Intn(360) - 180
 	case fieldTags.LongitudeDirection:
-		return fake.LongitudeDirection()
+		return []string{"W", "E"}[f.rand.Intn(2)]
 	case fieldTags.LongitudeMinutes:
-		return fake.LongitudeMinutes()
+		return f.rand.Intn(60)
 	case fieldTags.LongitudeSeconds:
-		return fake.LongitudeSeconds()
+		return f.rand.Intn(60)
 	case fieldTags.MaleFirstName:
-		return fake.MaleFirstName()
+		return f.firstName("male")
 	case fieldTags.MaleFullNameWithPrefix:
-		return fake.MaleFullNameWithPrefix()
+		return f.fullNameWithPrefix("male")
 	case fieldTags.MaleFullNameWithSuffix:
-		return fake.MaleFullNameWithSuffix()
+		return f.fullNameWithSuffix("male")
 	case fieldTags.MaleLastName:
-		return fake.MaleLastName()
+		return f.lastName("male")
 	case fieldTags.MalePratronymic:
-		return fake.MalePatronymic()
+		return f.patronymic("male")
 	case fieldTags.Model:
-		return fake.Model()
+		return f.model()
 	case fieldTags.Month:
-		return fake.Month()
+		return f.lookup("months", true)
 	case fieldTags.MonthNum:
-		return fake.MonthNum()
+		return f.rand.Intn(12) + 1
 	case fieldTags.MonthShort:
-		return fake.MonthShort()
+		return f.lookup("months_short", true)
 	case fieldTags.Paragraph:
-		return fake.Paragraph()
+		return f.paragraph()
 	case fieldTags.Patagraphs:
-		return fake.Paragraphs()
+		return f.paragraphsN(f.rand.Intn(5) + 1)
 	case fieldTags.PatagraphsN:
 		max := 5
 		if m, err := v.Tags.Int("max"); err == nil {
 			max = m
 		}
-		return fake.ParagraphsN(max)
+		return f.paragraphsN(max)
 	case fieldTags.Password:
 		var (
 			atLeast                                = 5
@@ -282,61 +408,73 @@ func genFakeData(v *Value) interface{} {
 		if special, err := v.Tags.Bool("special"); err == nil {
 			allowSpecial = special
 		}
-		return fake.Password(atLeast, atMost, allowUpper, allowNumeric, allowSpecial)
+		return f.text(atLeast, atMost, true, allowUpper, allowNumeric, allowSpecial)
 	case fieldTags.Patronymic:
-		return fake.Patronymic()
+		return f.patronymic(f.gender())
 	case fieldTags.Phone:
-		return fake.Phone()
+		return f.format("phones")
 	case fieldTags.Product:
-		return fake.Product()
+		return f.company() + " " + f.productName()
 	case fieldTags.ProductName:
-		return fake.ProductName()
+		return f.productName()
 	case fieldTags.Sentence:
-		return fake.Sentence()
+		return f.sentence()
 	case fieldTags.Sentences:
-		return fake.Sentence()
+		return f.sentencesN(f.rand.Intn(5) + 1)
 	case fieldTags.SentencesN:
 		max := 5
 		if m, err := v.Tags.Int("max"); err == nil {
 			max = m
 		}
-		return fake.SentencesN(max)
+		return f.sentencesN(max)
 	case fieldTags.SimplePassWord:
-		return fake.SimplePassword()
+		return f.text(6, 12, true, true, true, false)
 	case fieldTags.State:
-		return fake.State()
+		return f.lookup("states", false)
 	case fieldTags.StateAbbrev:
-		return fake.StateAbbrev()
+		return f.lookup("state_abbrevs", false)
 	case fieldTags.Street:
-		return fake.Street()
+		return f.street()
 	case fieldTags.StreetAddress:
-		return fake.StreetAddress()
+		return f.streetAddress()
 	case fieldTags.Title:
-		return fake.Title()
+		return strings.ToTitle(f.wordsN(2 + f.rand.Intn(4)))
 	case fieldTags.TopLevelDomain:
-		return fake.TopLevelDomain()
+		return f.lookup("top_level_domains", true)
 	case fieldTags.UserName:
-		return fake.UserName()
+		return f.userName()
 	case fieldTags.WeekDay:
-		return fake.WeekDay()
+		return f.lookup("weekdays", true)
 	case fieldTags.WeekDayNum:
-		return fake.WeekdayNum()
+		return f.rand.Intn(7) + 1
 	case fieldTags.WeekDayShort:
-		return fake.WeekDayShort()
+		return f.lookup("weekdays_short", true)
 	case fieldTags.Word:
-		return fake.Word()
+		return f.word()
 	case fieldTags.Words:
-		return fake.Words()
+		return f.wordsN(f.rand.Intn(5) + 1)
 	case fieldTags.WordsN:
 		max := 5
 		if m, err := v.Tags.Int("max"); err == nil {
 			max = m
 		}
-		return fake.WordsN(max)
+		return f.wordsN(max)
 	case fieldTags.Year:
-	//return fake.Year()
+		return spec.(yearSpec).year(g)
 	case fieldTags.Zip:
-		return fake.Zip()
+		return f.format("zips")
+	case fieldTags.Int, fieldTags.Float, fieldTags.Decimal:
+		return genNumber(g, typ, spec.(numberSpec))
+	case fieldTags.Date, fieldTags.DateTime, fieldTags.Time, fieldTags.Unix, fieldTags.UnixMs:
+		return genTime(g, typ, spec.(timeSpec))
+	case fieldTags.UUID, fieldTags.ULID, fieldTags.ObjectID, fieldTags.Hex, fieldTags.SHA256, fieldTags.Slug, fieldTags.Sequence:
+		return genID(g, typ, spec)
+	case fieldTags.OneOf:
+		return genChoice(g, spec.(choiceSpec), v.Data)
+	case fieldTags.Bool:
+		return genBool(g, spec.(float64))
+	case fieldTags.Pattern:
+		return genPattern(g, spec.(*syntax.Regexp))
 	}
 
 	return v.Data
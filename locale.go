@@ -0,0 +1,342 @@
+package apidemic
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/icrowley/fake"
+)
+
+// samples caches the locale data of the fake package by language and category, like
+// "en/cities". The data is read once and never changed, it is shared by every faker.
+var samples = struct {
+	sync.RWMutex
+	data map[string][]string
+}{data: make(map[string][]string)}
+
+// loadSamples returns the samples of category cat in lang, nil when there are none.
+func loadSamples(lang, cat string) []string {
+	key := lang + "/" + cat
+	samples.RLock()
+	s, ok := samples.data[key]
+	samples.RUnlock()
+	if ok {
+		return s
+	}
+
+	f, err := fake.FS(false).Open("/data/" + key)
+	if err == nil {
+		defer f.Close()
+		if b, err := ioutil.ReadAll(f); err == nil {
+			s = strings.Split(strings.TrimSpace(string(b)), "\n")
+		}
+	}
+	samples.Lock()
+	samples.data[key] = s
+	samples.Unlock()
+	return s
+}
+
+var (
+	lowerLetters = []rune("abcdefghijklmnopqrstuvwxyz")
+	upperLetters = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	numeric      = []rune("0123456789")
+	specialChars = []rune(`!'@#$%^&*()_+-=[]{};:",./?`)
+	hexDigits    = []rune("0123456789abcdef")
+)
+
+// creditCards are the vendors of the credit_card_num tag, by the name of the vendor option.
+var creditCards = map[string]struct {
+	length   int
+	prefixes []string
+}{
+	"visa":       {16, []string{"4539", "4556", "4916", "4532", "4929", "40240071", "4485", "4716", "4"}},
+	"mastercard": {16, []string{"51", "52", "53", "54", "55"}},
+	"amex":       {15, []string{"34", "37"}},
+	"discover":   {16, []string{"6011"}},
+}
+
+// faker generates the fake data of the tags backed by the locale data of the fake package.
+// It works like the functions of the fake package, but draws from rand and lang instead of
+// their global source and language, so that concurrent requests do not share any state.
+type faker struct {
+	rand *rand.Rand
+	lang string
+}
+
+// lookup picks one of the samples of category cat, from the english ones when lang has none
+// and fallback is set.
+func (f faker) lookup(cat string, fallback bool) string {
+	s := loadSamples(f.lang, cat)
+	if len(s) == 0 && fallback && f.lang != "en" {
+		s = loadSamples("en", cat)
+	}
+	if len(s) == 0 {
+		return ""
+	}
+	return s[f.rand.Intn(len(s))]
+}
+
+// format picks one of the formats of category cat and replaces its # by digits.
+func (f faker) format(cat string) string {
+	format := f.lookup(cat+"_format", true)
+	var b strings.Builder
+	for _, r := range format {
+		if r == '#' {
+			b.WriteRune(numeric[f.rand.Intn(10)])
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// join joins the parts which are not empty with spaces.
+func join(parts ...string) string {
+	var filtered []string
+	for _, part := range parts {
+		if part != "" {
+			filtered = append(filtered, part)
+		}
+	}
+	return strings.Join(filtered, " ")
+}
+
+func (f faker) text(atLeast, atMost int, allowLower, allowUpper, allowNumeric, allowSpecial bool) string {
+	var allowed []rune
+	if allowLower {
+		allowed = append(allowed, lowerLetters...)
+	}
+	if allowUpper {
+		allowed = append(allowed, upperLetters...)
+	}
+	if allowNumeric {
+		allowed = append(allowed, numeric...)
+	}
+	if allowSpecial {
+		allowed = append(allowed, specialChars...)
+	}
+	if atLeast < 0 {
+		atLeast = 0
+	}
+	if atMost < atLeast {
+		atMost = atLeast
+	}
+	n := atLeast + f.rand.Intn(atMost-atLeast+1)
+	out := make([]rune, n)
+	for i := range out {
+		out[i] = allowed[f.rand.Intn(len(allowed))]
+	}
+	return string(out)
+}
+
+func (f faker) runes(n int, set []rune) string {
+	if n < 0 {
+		n = 0
+	}
+	out := make([]rune, n)
+	for i := range out {
+		out[i] = set[f.rand.Intn(len(set))]
+	}
+	return string(out)
+}
+
+func (f faker) gender() string {
+	if f.rand.Intn(2) == 0 {
+		return "female"
+	}
+	return "male"
+}
+
+func (f faker) firstName(gender string) string {
+	return f.lookup(gender+"_first_names", true)
+}
+
+func (f faker) lastName(gender string) string {
+	return f.lookup(gender+"_last_names", true)
+}
+
+func (f faker) patronymic(gender string) string {
+	return f.lookup(gender+"_patronymics", false)
+}
+
+func (f faker) fullNameWithPrefix(gender string) string {
+	return join(f.lookup(gender+"_name_prefixes", false), f.firstName(gender), f.lastName(gender))
+}
+
+func (f faker) fullNameWithSuffix(gender string) string {
+	return join(f.firstName(gender), f.lastName(gender), f.lookup(gender+"_name_suffixes", false))
+}
+
+func (f faker) fullName(gender string) string {
+	switch f.rand.Intn(10) {
+	case 0:
+		return f.fullNameWithPrefix(gender)
+	case 1:
+		return f.fullNameWithSuffix(gender)
+	}
+	return join(f.firstName(gender), f.lastName(gender))
+}
+
+func (f faker) city() string {
+	city := f.lookup("cities", true)
+	switch f.rand.Intn(5) {
+	case 0:
+		return join(f.lookup("city_prefixes", false), city)
+	case 1:
+		return join(city, f.lookup("city_suffixes", false))
+	}
+	return city
+}
+
+func (f faker) street() string {
+	return join(f.lookup("streets", true), f.lookup("street_suffixes", true))
+}
+
+func (f faker) streetAddress() string {
+	return join(f.street(), strconv.Itoa(f.rand.Intn(100)))
+}
+
+// creditCardNum generates a card number of vendor, or of a random vendor when it is empty,
+// whose last digit is the Luhn check digit.
+func (f faker) creditCardNum(vendor string) string {
+	card, ok := creditCards[strings.ToLower(vendor)]
+	if !ok {
+		vendors := make([]string, 0, len(creditCards))
+		for v := range creditCards {
+			vendors = append(vendors, v)
+		}
+		sort.Strings(vendors)
+		card = creditCards[vendors[f.rand.Intn(len(vendors))]]
+	}
+	num := []byte(card.prefixes[f.rand.Intn(len(card.prefixes))])
+	for len(num) < card.length-1 {
+		num = append(num, byte('0'+f.rand.Intn(10)))
+	}
+	sum := 0
+	for i := len(num) - 1; i >= 0; i-- {
+		d := int(num[i] - '0')
+		if (len(num)-1-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return string(append(num, byte('0'+(10-sum%10)%10)))
+}
+
+func (f faker) character() string {
+	return f.lookup("characters", true)
+}
+
+func (f faker) charactersN(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString(f.character())
+	}
+	return b.String()
+}
+
+func (f faker) word() string {
+	return f.lookup("words", true)
+}
+
+func (f faker) wordsN(n int) string {
+	words := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		words = append(words, f.word())
+	}
+	return strings.Join(words, " ")
+}
+
+func (f faker) sentence() string {
+	n := 3 + f.rand.Intn(12)
+	words := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		word := f.word()
+		if f.rand.Intn(5) == 0 {
+			word += ","
+		}
+		words = append(words, word)
+	}
+	end := "."
+	if f.rand.Intn(8) == 0 {
+		end = "!"
+	}
+	return strings.TrimSuffix(strings.Join(words, " "), ",") + end
+}
+
+func (f faker) sentencesN(n int) string {
+	sentences := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		sentences = append(sentences, f.sentence())
+	}
+	return strings.Join(sentences, " ")
+}
+
+func (f faker) paragraph() string {
+	return f.sentencesN(f.rand.Intn(10) + 1)
+}
+
+func (f faker) paragraphsN(n int) string {
+	paragraphs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		paragraphs = append(paragraphs, f.paragraph())
+	}
+	return strings.Join(paragraphs, "\t")
+}
+
+func (f faker) company() string {
+	return f.lookup("companies", true)
+}
+
+func (f faker) productName() string {
+	name := f.lookup("adjectives", true) + " " + f.lookup("nouns", true)
+	if f.rand.Intn(2) == 1 {
+		name = f.lookup("adjectives", true) + " " + name
+	}
+	return name
+}
+
+func (f faker) model() string {
+	seps := []string{"", " ", "-"}
+	return f.charactersN(f.rand.Intn(3)+1) + seps[f.rand.Intn(len(seps))] + f.runes(f.rand.Intn(5)+1, numeric)
+}
+
+func (f faker) userName() string {
+	gender := f.gender()
+	switch f.rand.Intn(3) {
+	case 0:
+		return faker{rand: f.rand, lang: "en"}.lookup(gender+"_first_names", false) + f.lookup(gender+"_last_names", false)
+	case 1:
+		return f.character() + f.lookup(gender+"_last_names", false)
+	}
+	return strings.Replace(f.wordsN(f.rand.Intn(3)+1), " ", "_", -1)
+}
+
+func (f faker) domainName() string {
+	return f.company() + "." + f.lookup("top_level_domains", true)
+}
+
+func (f faker) ipv4() string {
+	ip := make(net.IP, 4)
+	for i := range ip {
+		ip[i] = byte(f.rand.Intn(256))
+	}
+	return ip.String()
+}
+
+func (f faker) genderAbbrev() string {
+	g := f.lookup("genders", true)
+	if g == "" {
+		return ""
+	}
+	return strings.ToLower(string([]rune(g)[:1]))
+}
@@ -0,0 +1,42 @@
+package apidemic
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakerDrawsFromItsOwnSource(t *testing.T) {
+	gen := func(lang string) []string {
+		f := faker{rand: rand.New(rand.NewSource(7)), lang: lang}
+		return []string{f.fullName(f.gender()), f.city(), f.sentence(), f.format("phones"), f.userName()}
+	}
+	en := gen("en")
+	assert.Equal(t, en, gen("en"))
+	for _, s := range en {
+		assert.NotEmpty(t, s)
+	}
+	assert.Regexp(t, regexp.MustCompile(`^[\p{Cyrillic}\s.]+$`), gen("ru")[0])
+}
+
+func TestFakerCreditCardNum(t *testing.T) {
+	f := faker{rand: rand.New(rand.NewSource(1)), lang: "en"}
+	for _, vendor := range []string{"visa", "mastercard", "amex", "discover", ""} {
+		num := f.creditCardNum(vendor)
+		sum := 0
+		for i := range num {
+			d := int(num[len(num)-1-i] - '0')
+			if i%2 == 1 {
+				d *= 2
+				if d > 9 {
+					d -= 9
+				}
+			}
+			sum += d
+		}
+		assert.Zero(t, sum%10, "%s %s", vendor, num)
+	}
+	assert.Len(t, f.creditCardNum("amex"), 15)
+}
@@ -0,0 +1,465 @@
+package apidemic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Match holds the conditions a request must satisfy, besides its path and method, to be
+// served by an API. When several APIs match a request the one with the most conditions wins.
+type Match struct {
+	Query   map[string]*ValueMatcher `json:"query,omitempty"`
+	Headers map[string]*ValueMatcher `json:"headers,omitempty"`
+	Body    *BodyMatcher             `json:"body,omitempty"`
+}
+
+// ValueMatcher is a condition on a query parameter or a header. It is satisfied when any of
+// the request values is equal to Equals or matches Regex. Present alone checks whether the
+// value is sent at all.
+type ValueMatcher struct {
+	Equals  *string `json:"equals,omitempty"`
+	Regex   string  `json:"regex,omitempty"`
+	Present *bool   `json:"present,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// BodyMatcher is a condition on the JSON request body. Subset is satisfied when every key
+// it holds is found in the body with an equal value, the body may hold more keys. Paths are
+// JSONPath predicates which all have to be satisfied.
+type BodyMatcher struct {
+	Subset interface{}    `json:"subset,omitempty"`
+	Paths  []*PathMatcher `json:"paths,omitempty"`
+}
+
+// PathMatcher is a JSONPath predicate on the request body, like $.user.id or $.items[*].sku.
+// It is satisfied when any value found at Path is equal to Equals or, for strings, matches
+// Regex. Exists alone checks whether any value is found at Path.
+type PathMatcher struct {
+	Path   string      `json:"path"`
+	Equals interface{} `json:"equals,omitempty"`
+	Regex  string      `json:"regex,omitempty"`
+	Exists *bool       `json:"exists,omitempty"`
+
+	steps []jsonPathStep
+	regex *regexp.Regexp
+}
+
+func (m *Match) load() error {
+	if m == nil {
+		return nil
+	}
+	for name, vm := range m.Query {
+		if err := vm.load(); err != nil {
+			return fmt.Errorf("apidemic: bad query matcher %s: %s", name, err)
+		}
+	}
+	for name, vm := range m.Headers {
+		if err := vm.load(); err != nil {
+			return fmt.Errorf("apidemic: bad header matcher %s: %s", name, err)
+		}
+	}
+	if m.Body != nil {
+		for _, pm := range m.Body.Paths {
+			if err := pm.load(); err != nil {
+				return fmt.Errorf("apidemic: bad body matcher %s: %s", pm.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// signature identifies the conditions, so that APIs sharing an endpoint but matching
+// different requests are registered side by side.
+func (m *Match) signature() string {
+	if m == nil {
+		return ""
+	}
+	b, _ := json.Marshal(m)
+	return "?" + string(b)
+}
+
+// weight is the number of conditions, the more there are the more specific the match is.
+func (m *Match) weight() int {
+	if m == nil {
+		return 0
+	}
+	n := len(m.Query) + len(m.Headers)
+	if m.Body != nil {
+		if m.Body.Subset != nil {
+			n++
+		}
+		n += len(m.Body.Paths)
+	}
+	return n
+}
+
+// check returns the reasons why the request does not satisfy the conditions, there are
+// none when it does.
+func (m *Match) check(r *http.Request, body []byte) []string {
+	if m == nil {
+		return nil
+	}
+
+	var reasons []string
+	query := r.URL.Query()
+	for _, name := range sortedKeys(m.Query) {
+		if reason := m.Query[name].check(query[name]); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("query %s: %s", name, reason))
+		}
+	}
+	for _, name := range sortedKeys(m.Headers) {
+		if reason := m.Headers[name].check(r.Header[http.CanonicalHeaderKey(name)]); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("header %s: %s", name, reason))
+		}
+	}
+	if m.Body != nil {
+		reasons = append(reasons, m.Body.check(body)...)
+	}
+	return reasons
+}
+
+func (vm *ValueMatcher) load() error {
+	if vm == nil || (vm.Equals == nil && vm.Regex == "" && vm.Present == nil) {
+		return errors.New("one of equals, regex or present must be set")
+	}
+	if vm.Present != nil && !*vm.Present && (vm.Equals != nil || vm.Regex != "") {
+		return errors.New("an absent value cannot be compared")
+	}
+	if vm.Regex != "" {
+		regex, err := regexp.Compile(vm.Regex)
+		if err != nil {
+			return err
+		}
+		vm.regex = regex
+	}
+	return nil
+}
+
+func (vm *ValueMatcher) check(values []string) string {
+	if vm.Present != nil && !*vm.Present {
+		if len(values) > 0 {
+			return "is present"
+		}
+		return ""
+	}
+	if len(values) == 0 {
+		return "is absent"
+	}
+	for _, v := range values {
+		if vm.Equals != nil && v != *vm.Equals {
+			continue
+		}
+		if vm.regex != nil && !vm.regex.MatchString(v) {
+			continue
+		}
+		return ""
+	}
+	if vm.Equals != nil {
+		return fmt.Sprintf("%q is not equal to %q", strings.Join(values, ","), *vm.Equals)
+	}
+	if vm.regex != nil {
+		return fmt.Sprintf("%q does not match %q", strings.Join(values, ","), vm.Regex)
+	}
+	return ""
+}
+
+func (bm *BodyMatcher) check(body []byte) []string {
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return []string{"body: is not valid JSON"}
+	}
+
+	var reasons []string
+	if bm.Subset != nil && !containsJSON(data, bm.Subset) {
+		reasons = append(reasons, "body: does not contain the expected subset")
+	}
+	for _, pm := range bm.Paths {
+		if reason := pm.check(data); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("body %s: %s", pm.Path, reason))
+		}
+	}
+	return reasons
+}
+
+func (pm *PathMatcher) load() error {
+	if pm == nil || (pm.Equals == nil && pm.Regex == "" && pm.Exists == nil) {
+		return errors.New("one of equals, regex or exists must be set")
+	}
+	if pm.Exists != nil && !*pm.Exists && (pm.Equals != nil || pm.Regex != "") {
+		return errors.New("a missing value cannot be compared")
+	}
+	steps, err := parseJSONPath(pm.Path)
+	if err != nil {
+		return err
+	}
+	pm.steps = steps
+	if pm.Regex != "" {
+		regex, err := regexp.Compile(pm.Regex)
+		if err != nil {
+			return err
+		}
+		pm.regex = regex
+	}
+	return nil
+}
+
+func (pm *PathMatcher) check(data interface{}) string {
+	found := evalJSONPath(pm.steps, data)
+	if pm.Exists != nil && !*pm.Exists {
+		if len(found) > 0 {
+			return "exists"
+		}
+		return ""
+	}
+	if len(found) == 0 {
+		return "does not exist"
+	}
+	for _, v := range found {
+		if pm.Equals != nil && !reflect.DeepEqual(v, pm.Equals) {
+			continue
+		}
+		if pm.regex != nil {
+			s, ok := v.(string)
+			if !ok || !pm.regex.MatchString(s) {
+				continue
+			}
+		}
+		return ""
+	}
+	if pm.Equals != nil {
+		return fmt.Sprintf("is not equal to %v", pm.Equals)
+	}
+	return fmt.Sprintf("does not match %q", pm.Regex)
+}
+
+// containsJSON reports whether expected is a subset of actual. Objects may hold more keys
+// and arrays more items than expected, scalars must be equal.
+func containsJSON(actual, expected interface{}) bool {
+	switch exp := expected.(type) {
+	case map[string]interface{}:
+		act, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, val := range exp {
+			item, ok := act[key]
+			if !ok || !containsJSON(item, val) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		act, ok := actual.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, val := range exp {
+			found := false
+			for _, item := range act {
+				if containsJSON(item, val) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(actual, expected)
+	}
+}
+
+// jsonPathStep is a single step of a JSONPath, it selects either the key of an object,
+// the index of an array or, when wildcard is set, every item of an object or an array.
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses the subset of JSONPath made of the root $, dot and bracket child
+// keys, array indexes and the * wildcard.
+//
+//	Example "$.items[0].tags[*]" or "$['first name']"
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path %q must start with $", path)
+	}
+
+	var steps []jsonPathStep
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			n := strings.IndexAny(rest, ".[")
+			if n < 0 {
+				n = len(rest)
+			}
+			key := rest[:n]
+			if key == "" {
+				return nil, fmt.Errorf("path %q has an empty key", path)
+			}
+			rest = rest[n:]
+			if key == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+				continue
+			}
+			steps = append(steps, jsonPathStep{key: key})
+		case '[':
+			n := strings.Index(rest, "]")
+			if n < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed [", path)
+			}
+			sel := rest[1:n]
+			rest = rest[n+1:]
+			switch {
+			case sel == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0]:
+				steps = append(steps, jsonPathStep{key: sel[1 : len(sel)-1]})
+			default:
+				index, err := strconv.Atoi(sel)
+				if err != nil {
+					return nil, fmt.Errorf("path %q has a bad index %q", path, sel)
+				}
+				steps = append(steps, jsonPathStep{index: index, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("path %q is not supported", path)
+		}
+	}
+	return steps, nil
+}
+
+// evalJSONPath returns every value of data found at the path.
+func evalJSONPath(steps []jsonPathStep, data interface{}) []interface{} {
+	found := []interface{}{data}
+	for _, step := range steps {
+		var next []interface{}
+		for _, v := range found {
+			switch node := v.(type) {
+			case map[string]interface{}:
+				if step.wildcard {
+					for _, key := range sortedKeys(node) {
+						next = append(next, node[key])
+					}
+				} else if val, ok := node[step.key]; ok && !step.isIndex {
+					next = append(next, val)
+				}
+			case []interface{}:
+				if step.wildcard {
+					next = append(next, node...)
+				} else if step.isIndex {
+					index := step.index
+					if index < 0 {
+						index += len(node)
+					}
+					if index >= 0 && index < len(node) {
+						next = append(next, node[index])
+					}
+				}
+			}
+		}
+		found = next
+	}
+	return found
+}
+
+func sortedKeys(m interface{}) []string {
+	rv := reflect.ValueOf(m)
+	keys := make([]string, 0, rv.Len())
+	for _, k := range rv.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// NearMiss is a registered API which almost matched an unmatched request, along with the
+// reasons why it did not.
+type NearMiss struct {
+	Endpoint   string   `json:"endpoint"`
+	HTTPMethod string   `json:"http_method"`
+	Reasons    []string `json:"reasons"`
+}
+
+// maxNearMisses limits the number of near misses reported for an unmatched request.
+const maxNearMisses = 5
+
+// findNearMisses returns the registered APIs closest to match the request, the ones with
+// the fewest failed criteria first. APIs whose endpoint has nothing in common with the
+// request path are left out.
+func (s *session) findNearMisses(r *http.Request, body []byte) []NearMiss {
+	misses := make([]NearMiss, 0)
+	for _, item := range s.store.Items() {
+		a := item.Object.(API)
+
+		var reasons []string
+		if a.HTTPMethod != r.Method {
+			reasons = append(reasons, fmt.Sprintf("method: expected %s, got %s", a.HTTPMethod, r.Method))
+		}
+		if _, ok := a.matchPath(r.URL.Path); !ok {
+			reason := pathMissReason(a, r.URL.Path)
+			if reason == "" {
+				continue
+			}
+			reasons = append(reasons, reason)
+		}
+		reasons = append(reasons, a.Match.check(r, body)...)
+		reasons = append(reasons, a.checkScenario(s.scenarios)...)
+		if a.exhausted() {
+			reasons = append(reasons, exhaustedReason)
+		}
+
+		endpoint := a.Endpoint
+		if a.regex != nil {
+			endpoint = a.EndpointRegex
+		}
+		misses = append(misses, NearMiss{Endpoint: endpoint, HTTPMethod: a.HTTPMethod, Reasons: reasons})
+	}
+
+	sort.Slice(misses, func(i, j int) bool {
+		if len(misses[i].Reasons) != len(misses[j].Reasons) {
+			return len(misses[i].Reasons) < len(misses[j].Reasons)
+		}
+		if misses[i].Endpoint != misses[j].Endpoint {
+			return misses[i].Endpoint < misses[j].Endpoint
+		}
+		return misses[i].HTTPMethod < misses[j].HTTPMethod
+	})
+	if len(misses) > maxNearMisses {
+		misses = misses[:maxNearMisses]
+	}
+	return misses
+}
+
+// pathMissReason explains how path almost matches the endpoint of a, it is empty when the
+// path is not even close.
+func pathMissReason(a API, path string) string {
+	slashed := path + "/"
+	if strings.HasSuffix(path, "/") {
+		slashed = strings.TrimSuffix(path, "/")
+	}
+	if _, ok := a.matchPath(slashed); ok {
+		return "path: differs by a trailing slash"
+	}
+	if a.regex == nil && strings.EqualFold(a.Endpoint, path) {
+		return "path: differs by letter case"
+	}
+	if _, ok := a.matchPath(strings.ToLower(path)); ok {
+		return "path: differs by letter case"
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package apidemic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONPath(t *testing.T) {
+	var data interface{}
+	err := json.Unmarshal([]byte(`{"user":{"id":1,"first name":"anton"},"items":[{"sku":"a"},{"sku":"b"}]}`), &data)
+	require.NoError(t, err)
+
+	sample := []struct {
+		path  string
+		found []interface{}
+	}{
+		{"$.user.id", []interface{}{float64(1)}},
+		{"$['user']['first name']", []interface{}{"anton"}},
+		{"$.items[1].sku", []interface{}{"b"}},
+		{"$.items[-1].sku", []interface{}{"b"}},
+		{"$.items[*].sku", []interface{}{"a", "b"}},
+		{"$.items[5].sku", nil},
+		{"$.user.missing", nil},
+	}
+	for _, v := range sample {
+		steps, err := parseJSONPath(v.path)
+		require.NoError(t, err, v.path)
+		assert.Equal(t, v.found, evalJSONPath(steps, data), v.path)
+	}
+
+	for _, path := range []string{"user.id", "$.items[", "$.items[x]", "$..id"} {
+		_, err := parseJSONPath(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestContainsJSON(t *testing.T) {
+	var actual interface{}
+	err := json.Unmarshal([]byte(`{"user":{"id":1,"name":"anton"},"tags":["a","b"],"note":null}`), &actual)
+	require.NoError(t, err)
+
+	sample := []struct {
+		expected string
+		ok       bool
+	}{
+		{`{"user":{"id":1}}`, true},
+		{`{"tags":["b"]}`, true},
+		{`{"note":null}`, true},
+		{`{"user":{"id":2}}`, false},
+		{`{"tags":["c"]}`, false},
+		{`{"missing":null}`, false},
+	}
+	for _, v := range sample {
+		var expected interface{}
+		require.NoError(t, json.Unmarshal([]byte(v.expected), &expected))
+		assert.Equal(t, v.ok, containsJSON(actual, expected), v.expected)
+	}
+}
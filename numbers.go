@@ -0,0 +1,118 @@
+package apidemic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// maxPrecision limits the number of decimals of the float and decimal tags.
+const maxPrecision = 15
+
+// numberSpec is how a number is generated, from the options of the int, float and decimal
+// tags. Numbers are picked between min and max, multiples of step away from min when step
+// is set, and rounded to precision decimals when precision is not negative. max defaults to
+// 100 above min.
+//
+//	Example "age:int,min=18,max=99" or "price:decimal,min=1,max=100,step=0.5"
+type numberSpec struct {
+	min, max  float64
+	step      float64
+	precision int
+}
+
+// numberSpec reads the options of the numeric tag typ.
+func (t Tags) numberSpec(typ string) (numberSpec, error) {
+	spec := numberSpec{precision: -1}
+	switch typ {
+	case fieldTags.Int:
+		spec.step = 1
+	case fieldTags.Decimal:
+		spec.precision = 2
+	}
+
+	for key, dst := range map[string]*float64{"min": &spec.min, "max": &spec.max, "step": &spec.step} {
+		if _, ok := t.Get(key); !ok {
+			continue
+		}
+		v, err := t.Float(key)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return numberSpec{}, fmt.Errorf("bad %s %q", key, t[key])
+		}
+		*dst = v
+	}
+	if _, ok := t.Get("max"); !ok {
+		spec.max = spec.min + 100
+	}
+	if _, ok := t.Get("precision"); ok {
+		if typ == fieldTags.Int {
+			return numberSpec{}, errors.New("precision is not used with int")
+		}
+		p, err := t.Int("precision")
+		if err != nil || p < 0 || p > maxPrecision {
+			return numberSpec{}, fmt.Errorf("bad precision %q, expected a number of decimals up to %d", t["precision"], maxPrecision)
+		}
+		spec.precision = p
+	}
+
+	if spec.max < spec.min {
+		return numberSpec{}, errors.New("max must not be less than min")
+	}
+	if spec.step < 0 {
+		return numberSpec{}, errors.New("step cannot be negative")
+	}
+	if spec.step > 0 && (spec.max-spec.min)/spec.step > 1<<53 {
+		return numberSpec{}, errors.New("step is too small for the range")
+	}
+	if typ == fieldTags.Int && (spec.min != math.Trunc(spec.min) || spec.max != math.Trunc(spec.max) || spec.step != math.Trunc(spec.step) || spec.step == 0) {
+		return numberSpec{}, errors.New("min, max and step of an int must be integers, step cannot be 0")
+	}
+	return spec, nil
+}
+
+// number picks a number according to spec.
+func (spec numberSpec) number(g *generator) float64 {
+	var v float64
+	if spec.step > 0 {
+		// The epsilon keeps max from being lost to rounding, like with 1/0.1.
+		n := int64((spec.max-spec.min)/spec.step + 1e-9)
+		v = spec.min + float64(g.rand.Int63n(n+1))*spec.step
+	} else {
+		v = spec.min + g.rand.Float64()*(spec.max-spec.min)
+	}
+	precision := spec.precision
+	if precision < 0 && spec.step > 0 {
+		precision = decimals(spec.step)
+	}
+	if precision >= 0 {
+		pow := math.Pow(10, float64(precision))
+		v = math.Round(v*pow) / pow
+	}
+	return math.Max(spec.min, math.Min(v, spec.max))
+}
+
+// genNumber generates the number of an int, float or decimal tag. Decimals keep their
+// trailing zeros, like 12.50.
+func genNumber(g *generator, typ string, spec numberSpec) interface{} {
+	v := spec.number(g)
+	switch typ {
+	case fieldTags.Int:
+		return int64(v)
+	case fieldTags.Decimal:
+		return json.Number(strconv.FormatFloat(v, 'f', spec.precision, 64))
+	}
+	return v
+}
+
+// decimals returns the number of decimals of v, like 2 for 0.25.
+func decimals(v float64) int {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	for i := range s {
+		if s[i] == '.' {
+			return len(s) - i - 1
+		}
+	}
+	return 0
+}
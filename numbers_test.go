@@ -0,0 +1,113 @@
+package apidemic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenNumber(t *testing.T) {
+	g := newGenerator(nil)
+	cases := []struct {
+		tags  string
+		check func(t *testing.T, v interface{})
+	}{
+		{"int,min=18,max=99", func(t *testing.T, v interface{}) {
+			require.IsType(t, int64(0), v)
+			assert.True(t, v.(int64) >= 18 && v.(int64) <= 99)
+		}},
+		{"int,min=0,max=100,step=25", func(t *testing.T, v interface{}) {
+			assert.Contains(t, []int64{0, 25, 50, 75, 100}, v)
+		}},
+		{"float,min=-1,max=1", func(t *testing.T, v interface{}) {
+			require.IsType(t, float64(0), v)
+			assert.True(t, v.(float64) >= -1 && v.(float64) <= 1)
+		}},
+		{"float,min=0,max=1,step=0.1", func(t *testing.T, v interface{}) {
+			assert.True(t, decimals(v.(float64)) <= 1, v)
+		}},
+		{"decimal,min=1,max=2,precision=3", func(t *testing.T, v interface{}) {
+			require.IsType(t, json.Number(""), v)
+			assert.Regexp(t, `^[12]\.\d{3}$`, v)
+		}},
+		{"decimal,min=10,max=10", func(t *testing.T, v interface{}) {
+			assert.Equal(t, json.Number("10.00"), v)
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.tags, func(t *testing.T) {
+			tags := make(Tags)
+			tags.Load(c.tags)
+			require.NoError(t, tags.check())
+			typ, _ := tags.Get("type")
+			spec, err := tags.numberSpec(typ)
+			require.NoError(t, err)
+			for i := 0; i < 100; i++ {
+				c.check(t, genNumber(g, typ, spec))
+			}
+		})
+	}
+}
+
+func TestNumberSpecRejectsBadOptions(t *testing.T) {
+	for _, src := range []string{
+		"int,min=ten",
+		"int,min=10,max=1",
+		"int,max=1.5",
+		"int,step=0",
+		"int,precision=2",
+		"float,step=-1",
+		"float,precision=20",
+		"decimal,max=1e300,step=1e-300",
+	} {
+		tags := make(Tags)
+		tags.Load(src)
+		assert.Error(t, tags.check(), src)
+	}
+}
+
+func TestNumberSpecDefaultMaxFollowsMin(t *testing.T) {
+	tags := make(Tags)
+	tags.Load("int,min=500")
+	spec, err := tags.numberSpec(fieldTags.Int)
+	require.NoError(t, err)
+	assert.Equal(t, float64(600), spec.max)
+}
+
+func TestGenerateNumberArrays(t *testing.T) {
+	g := newGenerator(nil)
+	value, err := loadValue(map[string]interface{}{
+		"scores:int,min=1,max=5,count=1":  []interface{}{0.0},
+		"big:int,min=200,max=203,count=3": []interface{}{0.0, 0.0},
+		"words:word,max=4":                []interface{}{""},
+	})
+	require.NoError(t, err)
+	require.NoError(t, checkTags(map[string]interface{}{
+		"scores:int,min=1,max=5,count=1":  []interface{}{0.0},
+		"big:int,min=200,max=203,count=3": []interface{}{0.0, 0.0},
+	}))
+
+	out := value.generate(g).(map[string]interface{})
+	require.Len(t, out["scores"], 1)
+	assert.True(t, out["scores"].([]interface{})[0].(int64) <= 5)
+	require.Len(t, out["big"], 3)
+	for _, v := range out["big"].([]interface{}) {
+		assert.True(t, v.(int64) >= 200 && v.(int64) <= 203, v)
+	}
+	assert.Len(t, out["words"], 4)
+}
+
+func TestCheckTagsOfArrayItems(t *testing.T) {
+	for _, key := range []string{
+		"big:int,min=200,max=100",
+		"scores:int,min=1,max=5",
+		"ratios:float,max=1",
+		"list:word,count=-1",
+		"list:word,max=many",
+		"list:int,count=3,step=0",
+	} {
+		assert.Error(t, checkTags(map[string]interface{}{key: []interface{}{0.0}}), key)
+	}
+}
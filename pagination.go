@@ -0,0 +1,241 @@
+package apidemic
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var paginationStyles = []string{"page", "offset", "cursor"}
+
+// Pagination serves the array payload of a response one page at a time. The payload holds a
+// single item, which may be annotated with tags, and the collection is made of Total items
+// generated from it. The page is picked from the query parameters of the request, depending
+// on the style:
+//
+//	page    ?page=2&per_page=20, pages start at 1
+//	offset  ?offset=20&limit=20
+//	cursor  ?cursor=...&limit=20, the cursor being the one given along with the previous page
+//
+// PerPage is the size of a page when the request does not tell, it defaults to 20 and
+// cannot be more than MaxPerPage, 100 by default.
+//
+// The total count and the links to the other pages are sent in the X-Total-Count and Link
+// headers, the cursor of the next page in X-Next-Cursor. With Envelope the payload is
+// instead an object holding the items along with the same details.
+type Pagination struct {
+	Style      string `json:"style,omitempty"`
+	Total      int    `json:"total"`
+	PerPage    int    `json:"per_page,omitempty"`
+	MaxPerPage int    `json:"max_per_page,omitempty"`
+	Envelope   bool   `json:"envelope,omitempty"`
+
+	item Value
+}
+
+func (p *Pagination) load(payload interface{}) error {
+	if p.Style == "" {
+		p.Style = "page"
+	}
+	if p.Style != "page" && p.Style != "offset" && p.Style != "cursor" {
+		return fmt.Errorf("apidemic: bad pagination style %q, expected one of %v", p.Style, paginationStyles)
+	}
+	if p.MaxPerPage == 0 {
+		p.MaxPerPage = 100
+	}
+	if p.PerPage == 0 {
+		p.PerPage = 20
+	}
+	if p.Total < 0 || p.PerPage < 0 || p.MaxPerPage < 0 {
+		return errors.New("apidemic: pagination cannot be negative")
+	}
+	if p.PerPage > p.MaxPerPage {
+		return errors.New("apidemic: pagination per_page must not be more than max_per_page")
+	}
+
+	items, ok := payload.([]interface{})
+	if !ok || len(items) != 1 {
+		return errors.New("apidemic: a paginated payload must be an array holding a single item")
+	}
+	item, err := loadValue(items[0])
+	if err != nil {
+		return err
+	}
+	p.item = item
+	return nil
+}
+
+// page is the window of the collection a request asks for.
+type page struct {
+	offset int
+	limit  int
+}
+
+// window returns the page asked for by the query of the request.
+func (p *Pagination) window(query url.Values) (page, error) {
+	pg := page{limit: p.PerPage}
+	limitParam := "limit"
+	if p.Style == "page" {
+		limitParam = "per_page"
+	}
+	if v := query.Get(limitParam); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return page{}, fmt.Errorf("apidemic: bad %s %q", limitParam, v)
+		}
+		pg.limit = n
+	}
+	if pg.limit > p.MaxPerPage {
+		pg.limit = p.MaxPerPage
+	}
+
+	switch p.Style {
+	case "page":
+		if v := query.Get("page"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return page{}, fmt.Errorf("apidemic: bad page %q", v)
+			}
+			pg.offset = (n - 1) * pg.limit
+		}
+	case "offset":
+		if v := query.Get("offset"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return page{}, fmt.Errorf("apidemic: bad offset %q", v)
+			}
+			pg.offset = n
+		}
+	case "cursor":
+		if v := query.Get("cursor"); v != "" {
+			n, err := decodeCursor(v)
+			if err != nil {
+				return page{}, fmt.Errorf("apidemic: bad cursor %q", v)
+			}
+			pg.offset = n
+		}
+	}
+	return pg, nil
+}
+
+// paginate renders the page pg of the collection, along with the headers describing it. Each
+// item is generated from its index in the collection, so that pages are slices of the same
+// collection whatever their size.
+func (p *Pagination) paginate(r *http.Request, pg page, data *templateData) (interface{}, http.Header, error) {
+	items := make([]interface{}, 0, pg.limit)
+	for i := pg.offset; i < p.Total && i < pg.offset+pg.limit; i++ {
+		itemData := *data
+		itemData.gen = data.gen.item(i)
+		item, err := renderTemplates(p.item.generate(itemData.gen), &itemData)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
+	}
+
+	header := make(http.Header)
+	var links []string
+	link := func(rel string, pg page) {
+		links = append(links, fmt.Sprintf("<%s>; rel=%q", p.pageURL(r, pg), rel))
+	}
+	hasNext := pg.offset+pg.limit < p.Total
+	next := page{offset: pg.offset + pg.limit, limit: pg.limit}
+	if p.Style == "cursor" {
+		if hasNext {
+			link("next", next)
+			header.Set("X-Next-Cursor", encodeCursor(next.offset))
+		}
+	} else {
+		link("first", page{limit: pg.limit})
+		if pg.offset > 0 {
+			prev := page{offset: pg.offset - pg.limit, limit: pg.limit}
+			if prev.offset < 0 {
+				prev.offset = 0
+			}
+			link("prev", prev)
+		}
+		if hasNext {
+			link("next", next)
+		}
+		last := 0
+		if p.Total > 0 {
+			last = (p.Total - 1) / pg.limit * pg.limit
+		}
+		link("last", page{offset: last, limit: pg.limit})
+	}
+
+	if !p.Envelope {
+		header.Set("X-Total-Count", strconv.Itoa(p.Total))
+		if len(links) > 0 {
+			header.Set("Link", strings.Join(links, ", "))
+		}
+		return items, header, nil
+	}
+
+	body := map[string]interface{}{
+		"items": items,
+		"total": p.Total,
+	}
+	switch p.Style {
+	case "page":
+		body["page"] = pg.offset/pg.limit + 1
+		body["per_page"] = pg.limit
+	case "offset":
+		body["offset"] = pg.offset
+		body["limit"] = pg.limit
+	case "cursor":
+		body["next_cursor"] = nil
+		if hasNext {
+			body["next_cursor"] = encodeCursor(next.offset)
+		}
+	}
+	return body, nil, nil
+}
+
+// pageURL returns the URL of the request pointing to another page. The URL is relative, and
+// keeps the session path prefix of the request if any.
+func (p *Pagination) pageURL(r *http.Request, pg page) string {
+	u := *r.URL
+	if r.RequestURI != "" {
+		if ru, err := url.ParseRequestURI(r.RequestURI); err == nil {
+			u = *ru
+		}
+	}
+
+	query := u.Query()
+	switch p.Style {
+	case "page":
+		query.Set("page", strconv.Itoa(pg.offset/pg.limit+1))
+		query.Set("per_page", strconv.Itoa(pg.limit))
+	case "offset":
+		query.Set("offset", strconv.Itoa(pg.offset))
+		query.Set("limit", strconv.Itoa(pg.limit))
+	case "cursor":
+		query.Set("cursor", encodeCursor(pg.offset))
+		query.Set("limit", strconv.Itoa(pg.limit))
+	}
+	return (&url.URL{Path: u.Path, RawQuery: query.Encode()}).String()
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(string(b), "offset:") {
+		return 0, errors.New("apidemic: unknown cursor")
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(b), "offset:"))
+	if err != nil || n < 0 {
+		return 0, errors.New("apidemic: unknown cursor")
+	}
+	return n, nil
+}
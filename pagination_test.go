@@ -0,0 +1,151 @@
+package apidemic
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func registerPaginated(t *testing.T, s *Server, p *Pagination) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/_register", API{
+		Endpoint: "/users",
+		Any: &Response{
+			Payload:    []interface{}{map[string]interface{}{"name:first_name": "anton"}},
+			Pagination: p,
+		},
+	}))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestPaginationWithPages(t *testing.T) {
+	s := setUp()
+	registerPaginated(t, s, &Pagination{Total: 45})
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/users?page=2&sort=name", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var items []map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&items))
+	assert.Len(t, items, 20)
+	assert.NotEmpty(t, items[0]["name"])
+	assert.Equal(t, "45", w.Header().Get("X-Total-Count"))
+	assert.Equal(t, `</users?page=1&per_page=20&sort=name>; rel="first", `+
+		`</users?page=1&per_page=20&sort=name>; rel="prev", `+
+		`</users?page=3&per_page=20&sort=name>; rel="next", `+
+		`</users?page=3&per_page=20&sort=name>; rel="last"`, w.Header().Get("Link"))
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/users?page=3", nil))
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&items))
+	assert.Len(t, items, 5)
+	assert.NotContains(t, w.Header().Get("Link"), `rel="next"`)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/users?page=0", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPaginationWithOffsetsInEnvelope(t *testing.T) {
+	s := setUp()
+	registerPaginated(t, s, &Pagination{Style: "offset", Total: 30, MaxPerPage: 50, Envelope: true})
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/users?offset=25&limit=100", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Items  []interface{} `json:"items"`
+		Total  int           `json:"total"`
+		Offset int           `json:"offset"`
+		Limit  int           `json:"limit"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Len(t, body.Items, 5)
+	assert.Equal(t, 30, body.Total)
+	assert.Equal(t, 25, body.Offset)
+	assert.Equal(t, 50, body.Limit)
+	assert.Empty(t, w.Header().Get("X-Total-Count"))
+}
+
+func TestPaginationWithCursors(t *testing.T) {
+	s := setUp()
+	registerPaginated(t, s, &Pagination{Style: "cursor", Total: 25, PerPage: 10})
+
+	var (
+		count  int
+		pages  int
+		cursor string
+	)
+	for {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/users?cursor="+cursor, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var items []interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&items))
+		count += len(items)
+		pages++
+
+		cursor = w.Header().Get("X-Next-Cursor")
+		if cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, 25, count)
+	assert.Equal(t, 3, pages)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/users?cursor=bogus", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPaginationPagesAreSlicesOfOneCollection(t *testing.T) {
+	for _, seed := range []*int64{nil, new(int64)} {
+		s := setUp()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", API{
+			Endpoint: "/users",
+			Seed:     seed,
+			Any: &Response{
+				Payload:    []interface{}{map[string]interface{}{"id:sequence": 0, "name:first_name": "anton"}},
+				Pagination: &Pagination{Total: 45, PerPage: 5},
+			},
+		}))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		get := func(query string) []map[string]interface{} {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("GET", "/users?"+query, nil))
+			require.Equal(t, http.StatusOK, w.Code)
+			var items []map[string]interface{}
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&items))
+			return items
+		}
+		pages := append(get("page=1"), get("page=2")...)
+		assert.Equal(t, get("per_page=10"), pages)
+		assert.Equal(t, pages, append(get("page=1"), get("page=2")...))
+		assert.Equal(t, 6.0, pages[5]["id"])
+	}
+}
+
+func TestRegisterRejectsInvalidPagination(t *testing.T) {
+	for _, rsp := range []Response{
+		{Payload: map[string]interface{}{}, Pagination: &Pagination{Total: 1}},
+		{Payload: []interface{}{1, 2}, Pagination: &Pagination{Total: 1}},
+		{Payload: []interface{}{1}, Pagination: &Pagination{Style: "seek"}},
+		{Payload: []interface{}{1}, Pagination: &Pagination{PerPage: 200}},
+		{Body: "[]", Pagination: &Pagination{Total: 1}},
+	} {
+		rsp := rsp
+		s := setUp()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_register", API{Endpoint: "/users", Any: &rsp}))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package apidemic
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var paramNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// pathPattern is a compiled endpoint template. Every segment of the template is either
+// a literal, a named parameter like {id} matching exactly one segment or, as the last
+// segment only, a catch all parameter like {path...} matching the rest of the path.
+//
+//	Example "/users/{id}/files/{path...}"
+type pathPattern struct {
+	segments []pathSegment
+}
+
+type pathSegment struct {
+	value string
+	param bool
+	rest  bool
+}
+
+// parsePathPattern compiles endpoint into a pathPattern.
+func parsePathPattern(endpoint string) (*pathPattern, error) {
+	p := &pathPattern{}
+	names := make(map[string]bool)
+	parts := strings.Split(endpoint, "/")
+	for i, part := range parts {
+		if !strings.ContainsAny(part, "{}") {
+			p.segments = append(p.segments, pathSegment{value: part})
+			continue
+		}
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			return nil, fmt.Errorf("apidemic: bad segment %q in endpoint %s, a parameter must take the whole segment", part, endpoint)
+		}
+
+		seg := pathSegment{value: part[1 : len(part)-1], param: true}
+		if strings.HasSuffix(seg.value, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("apidemic: bad segment %q in endpoint %s, only the last segment can match the rest of the path", part, endpoint)
+			}
+			seg.value = strings.TrimSuffix(seg.value, "...")
+			seg.rest = true
+		}
+		if !paramNameRegexp.MatchString(seg.value) {
+			return nil, fmt.Errorf("apidemic: bad parameter name %q in endpoint %s", seg.value, endpoint)
+		}
+		if names[seg.value] {
+			return nil, fmt.Errorf("apidemic: duplicate parameter name %q in endpoint %s", seg.value, endpoint)
+		}
+		names[seg.value] = true
+
+		p.segments = append(p.segments, seg)
+	}
+
+	return p, nil
+}
+
+// match reports whether path matches the pattern and returns the captured parameters.
+func (p *pathPattern) match(path string) (map[string]string, bool) {
+	parts := strings.Split(path, "/")
+	params := make(map[string]string)
+	for i, seg := range p.segments {
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch {
+		case seg.rest:
+			params[seg.value] = strings.Join(parts[i:], "/")
+			return params, true
+		case seg.param:
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg.value] = parts[i]
+		case seg.value != parts[i]:
+			return nil, false
+		}
+	}
+	if len(parts) != len(p.segments) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+// moreSpecific reports whether p should be preferred over o when both match the same
+// path. Segments are compared from left to right, a literal beats a parameter which in
+// turn beats a catch all parameter.
+func (p *pathPattern) moreSpecific(o *pathPattern) bool {
+	for i := 0; i < len(p.segments) && i < len(o.segments); i++ {
+		a, b := p.segments[i].rank(), o.segments[i].rank()
+		if a != b {
+			return a > b
+		}
+	}
+
+	return len(p.segments) > len(o.segments)
+}
+
+func (s pathSegment) rank() int {
+	switch {
+	case s.rest:
+		return 0
+	case s.param:
+		return 1
+	}
+
+	return 2
+}
@@ -0,0 +1,60 @@
+package apidemic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathPatternMatch(t *testing.T) {
+	samples := []struct {
+		pattern string
+		path    string
+		ok      bool
+		params  map[string]string
+	}{
+		{"/users", "/users", true, map[string]string{}},
+		{"/users", "/users/", false, nil},
+		{"/users/{id}", "/users/1", true, map[string]string{"id": "1"}},
+		{"/users/{id}", "/users/", false, nil},
+		{"/users/{id}", "/users/1/posts", false, nil},
+		{"/users/{id}/posts/{post}", "/users/1/posts/2", true, map[string]string{"id": "1", "post": "2"}},
+		{"/files/{path...}", "/files/a/b.txt", true, map[string]string{"path": "a/b.txt"}},
+		{"/files/{path...}", "/files/", true, map[string]string{"path": ""}},
+		{"/files/{path...}", "/files", false, nil},
+	}
+
+	for _, v := range samples {
+		p, err := parsePathPattern(v.pattern)
+		require.NoError(t, err)
+
+		params, ok := p.match(v.path)
+		assert.Equal(t, v.ok, ok, "%s %s", v.pattern, v.path)
+		assert.Equal(t, v.params, params, "%s %s", v.pattern, v.path)
+	}
+}
+
+func TestPathPatternInvalid(t *testing.T) {
+	for _, pattern := range []string{
+		"/users/{id",
+		"/users/id-{id}",
+		"/users/{}",
+		"/files/{path...}/raw",
+		"/users/{id}/posts/{id}",
+	} {
+		_, err := parsePathPattern(pattern)
+		assert.Error(t, err, pattern)
+	}
+}
+
+func TestPathPatternMoreSpecific(t *testing.T) {
+	literal, _ := parsePathPattern("/users/me")
+	param, _ := parsePathPattern("/users/{id}")
+	rest, _ := parsePathPattern("/users/{path...}")
+
+	assert.True(t, literal.moreSpecific(param))
+	assert.True(t, param.moreSpecific(rest))
+	assert.False(t, rest.moreSpecific(literal))
+	assert.False(t, param.moreSpecific(param))
+}
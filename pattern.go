@@ -0,0 +1,173 @@
+package apidemic
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// maxPatternRepeat limits how many times the unbounded repeats of the pattern tag, like *
+// and +, repeat on top of their minimum.
+const maxPatternRepeat = 10
+
+// printable is the range of the printable ASCII characters, the ones picked by . and negated
+// classes like [^0-9].
+var printable = []rune{' ', '~'}
+
+// patternRegexp parses the regex option of the pattern tag, which generates strings matching
+// it. Word boundaries cannot be generated and are rejected, so are anchors like ^ and $
+// anywhere but at the ends of the regex.
+//
+//	Example "sku:pattern,regex='[A-Z]{3}-\\d{4}'"
+func (t Tags) patternRegexp() (*syntax.Regexp, error) {
+	src, ok := t.Get("regex")
+	if !ok || src == "" {
+		return nil, errors.New("regex is missing")
+	}
+	re, err := syntax.Parse(src, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("bad regex %q: %s", src, err)
+	}
+	if err := checkPattern(trimAnchors(re)); err != nil {
+		return nil, fmt.Errorf("bad regex %q: %s", src, err)
+	}
+	return re, nil
+}
+
+// trimAnchors returns re without its leading ^ and trailing $, which match anything
+// generated.
+func trimAnchors(re *syntax.Regexp) *syntax.Regexp {
+	if re.Op != syntax.OpConcat {
+		if isAnchor(re) {
+			return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+		}
+		return re
+	}
+	subs := re.Sub
+	for len(subs) > 0 && (subs[0].Op == syntax.OpBeginText || subs[0].Op == syntax.OpBeginLine) {
+		subs = subs[1:]
+	}
+	for len(subs) > 0 && (subs[len(subs)-1].Op == syntax.OpEndText || subs[len(subs)-1].Op == syntax.OpEndLine) {
+		subs = subs[:len(subs)-1]
+	}
+	trimmed := *re
+	trimmed.Sub = subs
+	return &trimmed
+}
+
+// isAnchor reports whether re is ^ or $.
+func isAnchor(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpBeginText, syntax.OpBeginLine, syntax.OpEndText, syntax.OpEndLine:
+		return true
+	}
+	return false
+}
+
+// checkPattern reports the parts of re which cannot be generated.
+func checkPattern(re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return errors.New("word boundaries are not supported")
+	case syntax.OpBeginText, syntax.OpBeginLine, syntax.OpEndText, syntax.OpEndLine:
+		return errors.New("^ and $ are only supported at the ends")
+	case syntax.OpNoMatch:
+		return errors.New("it matches nothing")
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return errors.New("it has an empty class")
+		}
+	}
+	for _, sub := range re.Sub {
+		if err := checkPattern(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// genPattern generates the value of a pattern tag matching re.
+func genPattern(g *generator, re *syntax.Regexp) interface{} {
+	var b strings.Builder
+	writePattern(g, &b, re)
+	return b.String()
+}
+
+// writePattern writes a random string matching re to b.
+func writePattern(g *generator, b *strings.Builder, re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 && g.rand.Intn(2) == 0 {
+				r = unicode.SimpleFold(r)
+			}
+			b.WriteRune(r)
+		}
+	case syntax.OpCharClass:
+		b.WriteRune(pickRune(g, re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		b.WriteRune(pickRune(g, printable))
+	case syntax.OpCapture:
+		writePattern(g, b, re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			writePattern(g, b, sub)
+		}
+	case syntax.OpAlternate:
+		writePattern(g, b, re.Sub[g.rand.Intn(len(re.Sub))])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		min, max := re.Min, re.Max
+		switch re.Op {
+		case syntax.OpStar:
+			min, max = 0, -1
+		case syntax.OpPlus:
+			min, max = 1, -1
+		case syntax.OpQuest:
+			min, max = 0, 1
+		}
+		if max < 0 {
+			max = min + maxPatternRepeat
+		}
+		for n := min + g.rand.Intn(max-min+1); n > 0; n-- {
+			writePattern(g, b, re.Sub[0])
+		}
+	}
+}
+
+// pickRune picks a rune of the ranges, a list of pairs as in syntax.Regexp. Printable ASCII
+// characters are preferred, so that classes like [^0-9] do not pick control characters or
+// characters of any script.
+func pickRune(g *generator, ranges []rune) rune {
+	var ascii []rune
+	for i := 0; i+1 < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		if lo < printable[0] {
+			lo = printable[0]
+		}
+		if hi > printable[1] {
+			hi = printable[1]
+		}
+		if lo <= hi {
+			ascii = append(ascii, lo, hi)
+		}
+	}
+	if len(ascii) > 0 {
+		ranges = ascii
+	}
+
+	n := 0
+	for i := 0; i+1 < len(ranges); i += 2 {
+		n += int(ranges[i+1]-ranges[i]) + 1
+	}
+	k := g.rand.Intn(n)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		size := int(ranges[i+1]-ranges[i]) + 1
+		if k < size {
+			return ranges[i] + rune(k)
+		}
+		k -= size
+	}
+	return ranges[0]
+}
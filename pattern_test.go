@@ -0,0 +1,68 @@
+package apidemic
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenPattern(t *testing.T) {
+	g := newGenerator(nil)
+	for _, src := range []string{
+		`[A-Z]{3}-\d{4}`,
+		`^GB\d{2}[A-Z]{4}\d{14}$`,
+		`(?i)sku_[a-f0-9]+`,
+		`[^0-9]{2,5}x?`,
+		`(cat|dog)s?\.\w*`,
+		`.+@example\.(com|org)`,
+		`\p{Greek}{3}`,
+	} {
+		t.Run(src, func(t *testing.T) {
+			tags := Tags{"type": "pattern", "regex": src}
+			require.NoError(t, tags.check())
+			spec, err := tags.patternRegexp()
+			require.NoError(t, err)
+			re := regexp.MustCompile(`^(?:` + src + `)$`)
+			for i := 0; i < 50; i++ {
+				v := genPattern(g, spec)
+				assert.Regexp(t, re, v)
+			}
+		})
+	}
+}
+
+func TestGenPatternIsSeeded(t *testing.T) {
+	seed := int64(42)
+	tags := make(Tags)
+	tags.Load(`pattern,regex='[A-Z]{2,4}-\d{1,3}'`)
+	re, err := tags.patternRegexp()
+	require.NoError(t, err)
+	assert.Equal(t, genPattern(newGenerator(&seed), re), genPattern(newGenerator(&seed), re))
+}
+
+func TestLoadValueCompilesPatterns(t *testing.T) {
+	value, err := loadValue(map[string]interface{}{"sku:pattern,regex='^[A-Z]{3}$'": ""})
+	require.NoError(t, err)
+	sku := value.Data.(map[string]Value)["sku"]
+	assert.IsType(t, &syntax.Regexp{}, sku.spec)
+	assert.Regexp(t, `^[A-Z]{3}$`, sku.generate(newGenerator(nil)))
+}
+
+func TestPatternRejectsBadRegex(t *testing.T) {
+	for _, src := range []string{
+		"pattern",
+		"pattern,regex=[a-z",
+		`pattern,regex=\bword\b`,
+		`pattern,regex=a$b`,
+		`pattern,regex=a^b`,
+		`pattern,regex=(^a|b)`,
+		`pattern,regex=[^\x00-\x{10FFFF}]`,
+	} {
+		tags := make(Tags)
+		tags.Load(src)
+		assert.Error(t, tags.check(), src)
+	}
+}
@@ -0,0 +1,59 @@
+package apidemic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// presence is whether a field of a generated object is there, null or absent.
+type presence int
+
+const (
+	fieldPresent presence = iota
+	fieldNull
+	fieldOmitted
+)
+
+// rates reads the null_rate and omit_rate options, the probabilities of a field to be null
+// and to be absent from its object. Both default to 0.
+//
+//	Example "nickname:user_name,null_rate=0.1,omit_rate=0.2"
+func (t Tags) rates() (float64, float64, error) {
+	var rates [2]float64
+	for i, key := range []string{"null_rate", "omit_rate"} {
+		if _, ok := t.Get(key); !ok {
+			continue
+		}
+		v, err := t.Float(key)
+		if err != nil || !(v >= 0 && v <= 1) {
+			return 0, 0, fmt.Errorf("bad %s %q, expected a number between 0 and 1", key, t[key])
+		}
+		rates[i] = v
+	}
+	if rates[0]+rates[1] > 1 {
+		return 0, 0, errors.New("null_rate and omit_rate cannot add up to more than 1")
+	}
+	return rates[0], rates[1], nil
+}
+
+// presence picks whether the field holding v is there, null or absent. Nothing is drawn from
+// g unless v has rates, so that seeded data stays the same for the fields without.
+func (v Value) presence(g *generator) presence {
+	if _, ok := v.Tags["null_rate"]; !ok {
+		if _, ok := v.Tags["omit_rate"]; !ok {
+			return fieldPresent
+		}
+	}
+	nullRate, omitRate, err := v.Tags.rates()
+	if err != nil {
+		return fieldPresent
+	}
+	x := g.rand.Float64()
+	switch {
+	case x < omitRate:
+		return fieldOmitted
+	case x < omitRate+nullRate:
+		return fieldNull
+	}
+	return fieldPresent
+}
@@ -0,0 +1,69 @@
+package apidemic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateWithRates(t *testing.T) {
+	g := newGenerator(nil)
+	value, err := loadValue(map[string]interface{}{
+		"name:full_name":                          "",
+		"nickname:user_name,null_rate=0.3":        "",
+		"email:email_address,omit_rate=0.3":       "",
+		"phone:phone,null_rate=0.5,omit_rate=0.5": "",
+		"tags:word,max=2,null_rate=1":             []interface{}{""},
+	})
+	require.NoError(t, err)
+
+	var nulls, omits int
+	for i := 0; i < 1000; i++ {
+		out := value.generate(g).(map[string]interface{})
+		assert.NotEmpty(t, out["name"])
+		assert.Nil(t, out["tags"])
+
+		nickname, ok := out["nickname"]
+		require.True(t, ok)
+		if nickname == nil {
+			nulls++
+		}
+		email, ok := out["email"]
+		if !ok {
+			omits++
+		} else {
+			assert.NotNil(t, email)
+		}
+		phone, ok := out["phone"]
+		assert.True(t, !ok || phone == nil)
+	}
+	assert.InDelta(t, 300, nulls, 75)
+	assert.InDelta(t, 300, omits, 75)
+}
+
+func TestMarshalValueWithRates(t *testing.T) {
+	obj := NewObject()
+	require.NoError(t, obj.Load(map[string]interface{}{
+		"id:sequence":              0.0,
+		"gone:word,omit_rate=1":    "",
+		"empty:word,null_rate=1.0": "",
+	}))
+	b, err := json.Marshal(NewValue(obj.Data))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":1,"empty":null}`, string(b))
+}
+
+func TestRatesRejectsBadOptions(t *testing.T) {
+	for _, src := range []string{
+		"word,null_rate=-0.1",
+		"word,omit_rate=2",
+		"word,omit_rate=often",
+		"word,null_rate=0.6,omit_rate=0.6",
+	} {
+		tags := make(Tags)
+		tags.Load(src)
+		assert.Error(t, tags.check(), src)
+	}
+}
@@ -0,0 +1,272 @@
+package apidemic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxResourceCount limits the number of items seeded in a resource.
+const maxResourceCount = 10000
+
+// Resource is the struct for the json object that is passed to apidemic to register a REST
+// resource. The resource is a collection of JSON objects served under its path, like /users,
+// with the usual semantics:
+//
+//	GET    /users       lists the items
+//	POST   /users       creates an item, its id is assigned unless the body sets it
+//	GET    /users/{id}  gets an item
+//	PUT    /users/{id}  replaces an item
+//	PATCH  /users/{id}  updates the fields of an item, null fields are removed
+//	DELETE /users/{id}  deletes an item
+//
+// The collection is seeded with Count items generated from Payload, whose keys may be
+// annotated with tags like the payload of a Response. IDField names the id of the items, it
+// defaults to id. Registered APIs take precedence over resources.
+type Resource struct {
+	Resource string      `json:"resource"`
+	IDField  string      `json:"id_field,omitempty"`
+	Payload  interface{} `json:"payload,omitempty"`
+	Count    int         `json:"count,omitempty"`
+}
+
+func (res *Resource) load() (Value, error) {
+	if !strings.HasPrefix(res.Resource, "/") || strings.ContainsAny(res.Resource, "{}") {
+		return Value{}, fmt.Errorf("apidemic: bad resource %q, expected a path like /users", res.Resource)
+	}
+	res.Resource = strings.TrimRight(res.Resource, "/")
+	if res.Resource == "" || strings.HasPrefix(res.Resource, "/_") {
+		return Value{}, fmt.Errorf("apidemic: bad resource %q, expected a path like /users", res.Resource)
+	}
+	if res.IDField == "" {
+		res.IDField = "id"
+	}
+	if res.Count < 0 || res.Count > maxResourceCount {
+		return Value{}, fmt.Errorf("apidemic: resource count must be between 0 and %d", maxResourceCount)
+	}
+	if res.Payload == nil {
+		return NewValue(map[string]interface{}{}), nil
+	}
+	if _, ok := res.Payload.(map[string]interface{}); !ok {
+		return Value{}, errors.New("apidemic: resource payload must be an object")
+	}
+	if err := checkTags(res.Payload); err != nil {
+		return Value{}, err
+	}
+	return loadValue(res.Payload)
+}
+
+// collection holds the items of a registered resource, in the order they were created.
+type collection struct {
+	mu      sync.Mutex
+	idField string
+	items   []map[string]interface{}
+	nextID  int
+}
+
+func newCollection(res Resource, value Value, gen *generator) *collection {
+	c := &collection{idField: res.IDField, nextID: 1}
+	for i := 0; i < res.Count; i++ {
+		item, _ := value.generate(gen).(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+		}
+		item[c.idField] = c.newID()
+		c.items = append(c.items, item)
+	}
+	return c
+}
+
+// newID returns the next free numeric id.
+func (c *collection) newID() int {
+	for c.find(strconv.Itoa(c.nextID)) >= 0 {
+		c.nextID++
+	}
+	id := c.nextID
+	c.nextID++
+	return id
+}
+
+// find returns the index of the item with the id, -1 when there is none.
+func (c *collection) find(id string) int {
+	for i, item := range c.items {
+		if idKey(item[c.idField]) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// idKey returns the string form of an id as found in a path, ids can be strings or numbers.
+func idKey(id interface{}) string {
+	switch id := id.(type) {
+	case string:
+		return id
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(id)
+	}
+	return ""
+}
+
+// RegisterResourceHandler registers a Resource.
+func (s *Server) RegisterResourceHandler(w http.ResponseWriter, r *http.Request) {
+	res := Resource{}
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		log.Print(err)
+
+		RenderJSON(w, http.StatusBadRequest, NewResponse(err.Error()))
+		return
+	}
+	var gen *generator
+	value, err := res.load()
+	if err == nil {
+		gen, err = s.generatorFor(r, nil, nil)
+	}
+	if err != nil {
+		log.Print(err)
+
+		RenderJSON(w, http.StatusBadRequest, NewResponse(err.Error()))
+		return
+	}
+
+	ss := s.sessionToWrite(r)
+	ss.mu.Lock()
+	ss.resources[res.Resource] = newCollection(res, value, gen)
+	ss.mu.Unlock()
+	RenderJSON(w, http.StatusOK, NewResponse("cool"))
+}
+
+// findResource returns the registered resource serving path along with the id of the item
+// path refers to, the id is empty for the collection itself.
+func (s *session) findResource(path string) (string, *collection, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.resources[strings.TrimRight(path, "/")]; ok {
+		return strings.TrimRight(path, "/"), c, "", true
+	}
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return "", nil, "", false
+	}
+	if c, ok := s.resources[path[:i]]; ok {
+		return path[:i], c, path[i+1:], true
+	}
+	return "", nil, "", false
+}
+
+// serveResource serves the request when it is for a registered resource, it reports
+// whether it did.
+func (s *session) serveResource(w http.ResponseWriter, r *http.Request, body []byte, event map[string]interface{}) bool {
+	name, c, id, ok := s.findResource(r.URL.Path)
+	if !ok {
+		return false
+	}
+
+	code, rsp, header := c.serve(r.Method, name, id, body)
+	event["resource"] = name
+	event["response_status"] = code
+	event["response_headers"] = header
+	event["response_body"] = rsp
+	s.saveEvent(event)
+
+	for key, values := range header {
+		w.Header()[key] = values
+	}
+	RenderJSON(w, code, rsp)
+	return true
+}
+
+// serve runs method against the collection or, when id is set, against one of its items.
+func (c *collection) serve(method, name, id string, body []byte) (int, interface{}, http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := make(http.Header)
+	if id == "" {
+		switch method {
+		case "GET", "HEAD":
+			items := make([]interface{}, 0, len(c.items))
+			for _, item := range c.items {
+				items = append(items, item)
+			}
+			return http.StatusOK, items, header
+		case "POST":
+			item, err := decodeItem(body)
+			if err != nil {
+				return http.StatusBadRequest, NewResponse(err.Error()), header
+			}
+			if _, ok := item[c.idField]; !ok {
+				item[c.idField] = c.newID()
+			}
+			key := idKey(item[c.idField])
+			if key == "" {
+				return http.StatusBadRequest, NewResponse(fmt.Sprintf("apidemic: bad %s, expected a string or a number", c.idField)), header
+			}
+			if c.find(key) >= 0 {
+				return http.StatusConflict, NewResponse(fmt.Sprintf("apidemic: %s/%s already exists", name, key)), header
+			}
+			c.items = append(c.items, item)
+			header.Set("Location", name+"/"+key)
+			return http.StatusCreated, item, header
+		}
+		header.Set("Allow", "GET, HEAD, POST")
+		return http.StatusMethodNotAllowed, NewResponse(fmt.Sprintf("apidemic: %s does not support %s", name, method)), header
+	}
+
+	i := c.find(id)
+	switch method {
+	case "GET", "HEAD", "PUT", "PATCH", "DELETE":
+		if i < 0 {
+			return http.StatusNotFound, NewResponse(fmt.Sprintf("apidemic: %s/%s not found", name, id)), header
+		}
+	default:
+		header.Set("Allow", "GET, HEAD, PUT, PATCH, DELETE")
+		return http.StatusMethodNotAllowed, NewResponse(fmt.Sprintf("apidemic: %s/%s does not support %s", name, id, method)), header
+	}
+
+	switch method {
+	case "PUT", "PATCH":
+		item, err := decodeItem(body)
+		if err != nil {
+			return http.StatusBadRequest, NewResponse(err.Error()), header
+		}
+		if v, ok := item[c.idField]; ok && idKey(v) != id {
+			return http.StatusConflict, NewResponse(fmt.Sprintf("apidemic: %s of %s/%s cannot be changed", c.idField, name, id)), header
+		}
+		if method == "PATCH" {
+			patch := item
+			item = make(map[string]interface{}, len(c.items[i]))
+			for key, val := range c.items[i] {
+				item[key] = val
+			}
+			for key, val := range patch {
+				if val == nil {
+					delete(item, key)
+					continue
+				}
+				item[key] = val
+			}
+		}
+		item[c.idField] = c.items[i][c.idField]
+		c.items[i] = item
+	case "DELETE":
+		c.items = append(c.items[:i], c.items[i+1:]...)
+		return http.StatusNoContent, nil, header
+	}
+	return http.StatusOK, c.items[i], header
+}
+
+func decodeItem(body []byte) (map[string]interface{}, error) {
+	var item map[string]interface{}
+	if err := json.Unmarshal(body, &item); err != nil || item == nil {
+		return nil, errors.New("apidemic: the body must be a JSON object")
+	}
+	return item, nil
+}
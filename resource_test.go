@@ -0,0 +1,119 @@
+package apidemic
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResource(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	s.ServeHTTP(w, jsonRequest("POST", "/_resource", Resource{
+		Resource: "/users",
+		Payload:  map[string]interface{}{"name:full_name": "anton", "email:email_address": "anton@example.com"},
+		Count:    3,
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	do := func(method, path string, body interface{}) (int, interface{}) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest(method, path, body))
+		var out interface{}
+		if w.Code != http.StatusNoContent {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&out))
+		}
+		return w.Code, out
+	}
+
+	code, list := do("GET", "/users", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, list, 3)
+	for i, item := range list.([]interface{}) {
+		user := item.(map[string]interface{})
+		assert.Equal(t, float64(i+1), user["id"])
+		assert.NotEmpty(t, user["name"])
+		assert.NotEqual(t, "anton@example.com", user["email"])
+	}
+
+	code, user := do("POST", "/users", map[string]interface{}{"name": "jane"})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, map[string]interface{}{"id": float64(4), "name": "jane"}, user)
+
+	code, _ = do("POST", "/users", map[string]interface{}{"id": 4, "name": "john"})
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, user = do("PATCH", "/users/4", map[string]interface{}{"email": "jane@example.com"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"id": float64(4), "name": "jane", "email": "jane@example.com"}, user)
+
+	code, user = do("PUT", "/users/4", map[string]interface{}{"name": "janet"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"id": float64(4), "name": "janet"}, user)
+
+	code, _ = do("PUT", "/users/4", map[string]interface{}{"id": 5})
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, user = do("GET", "/users/4", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"id": float64(4), "name": "janet"}, user)
+
+	code, _ = do("DELETE", "/users/4", "")
+	assert.Equal(t, http.StatusNoContent, code)
+
+	code, _ = do("GET", "/users/4", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = do("DELETE", "/users", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	code, _ = do("POST", "/users", "not an object")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, list = do("GET", "/users", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, list, 3)
+}
+
+func TestResourceGivesWayToAPIs(t *testing.T) {
+	s := setUp()
+	w := httptest.NewRecorder()
+	defer resetEndpoints(s, w)
+
+	s.ServeHTTP(w, jsonRequest("POST", "/_resource", Resource{Resource: "/users", Count: 1}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("POST", "/_register", API{Endpoint: "/users/{id}", Any: &Response{Code: http.StatusTeapot}}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/users/1", ""))
+	assert.Equal(t, http.StatusTeapot, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, jsonRequest("GET", "/users", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"id":1}]`, w.Body.String())
+}
+
+func TestRegisterRejectsInvalidResource(t *testing.T) {
+	for _, res := range []Resource{
+		{Resource: "users"},
+		{Resource: "/users/{id}"},
+		{Resource: "/_history"},
+		{Resource: "/users", Count: -1},
+		{Resource: "/users", Payload: []interface{}{}},
+	} {
+		s := setUp()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, jsonRequest("POST", "/_resource", res))
+		assert.Equal(t, http.StatusBadRequest, w.Code, res.Resource)
+	}
+}
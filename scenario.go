@@ -0,0 +1,101 @@
+package apidemic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ScenarioStarted is the state every scenario starts in.
+const ScenarioStarted = "started"
+
+// scenarios holds the current state of the scenarios of a session, a scenario is in the
+// ScenarioStarted state until an API moves it to another one.
+type scenarios struct {
+	mu     sync.Mutex
+	states map[string]string
+}
+
+func newScenarios() *scenarios {
+	return &scenarios{states: make(map[string]string)}
+}
+
+func (sc *scenarios) state(name string) string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if state, ok := sc.states[name]; ok {
+		return state
+	}
+	return ScenarioStarted
+}
+
+func (sc *scenarios) set(name, state string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.states[name] = state
+}
+
+func (sc *scenarios) reset() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.states = make(map[string]string)
+}
+
+// allScenarios returns the state of every scenario which is either known to the registered
+// APIs or was moved to another state.
+func (s *session) allScenarios() map[string]string {
+	out := make(map[string]string)
+	for _, item := range s.store.Items() {
+		if a := item.Object.(API); a.Scenario != "" {
+			out[a.Scenario] = ScenarioStarted
+		}
+	}
+
+	s.scenarios.mu.Lock()
+	defer s.scenarios.mu.Unlock()
+	for name, state := range s.scenarios.states {
+		out[name] = state
+	}
+	return out
+}
+
+func (a *API) loadScenario() error {
+	if a.Scenario == "" && (a.RequiredState != "" || a.NewState != "") {
+		return errors.New("apidemic: required_state and new_state need a scenario")
+	}
+	return nil
+}
+
+// scenarioSignature tells apart the APIs of the same endpoint serving different states of
+// a scenario.
+func (a API) scenarioSignature() string {
+	if a.Scenario == "" {
+		return ""
+	}
+	return fmt.Sprintf("#%s=%s", a.Scenario, a.RequiredState)
+}
+
+// checkScenario returns why the current state of the scenario of a does not allow it to
+// serve a request.
+func (a API) checkScenario(sc *scenarios) []string {
+	if a.RequiredState == "" {
+		return nil
+	}
+	if state := sc.state(a.Scenario); state != a.RequiredState {
+		return []string{fmt.Sprintf("scenario %s: expected state %q, got %q", a.Scenario, a.RequiredState, state)}
+	}
+	return nil
+}
+
+// ScenariosHandler renders the current state of every scenario.
+func (s *Server) ScenariosHandler(w http.ResponseWriter, r *http.Request) {
+	RenderJSON(w, http.StatusOK, s.session(r).allScenarios())
+}
+
+// ResetScenariosHandler moves every scenario back to the ScenarioStarted state.
+func (s *Server) ResetScenariosHandler(w http.ResponseWriter, r *http.Request) {
+	s.session(r).scenarios.reset()
+
+	RenderJSON(w, http.StatusOK, nil)
+}
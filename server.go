@@ -0,0 +1,102 @@
+package apidemic
+
+import (
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Server is an apidemic server. Every server has its own registered endpoints and history,
+// so that several servers can run side by side, like in parallel tests. Within a server the
+// endpoints and history are further scoped by session.
+type Server struct {
+	sessions   map[string]*session
+	sessionsMu sync.Mutex
+	swept      time.Time
+	handler    *RegexpHandler
+
+	fixturesDir string
+	seed        *int64
+	lang        string
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithFixturesDir sets the directory the body_file of the responses is looked up in, it
+// defaults to the current directory.
+func WithFixturesDir(dir string) Option {
+	return func(s *Server) {
+		s.fixturesDir = dir
+	}
+}
+
+// WithSeed makes the fake data deterministic, the same request always gets the same data.
+// Registered APIs and requests can set their own seed, see generatorFor.
+func WithSeed(seed int64) Option {
+	return func(s *Server) {
+		s.seed = &seed
+	}
+}
+
+// WithLang sets the default language of the fake data, en unless set. Registered APIs and
+// tags can set their own language.
+func WithLang(lang string) Option {
+	return func(s *Server) {
+		s.lang = lang
+	}
+}
+
+// NewServer returns a new apidemic server
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		sessions:    map[string]*session{"": newSession()},
+		handler:     &RegexpHandler{},
+		fixturesDir: ".",
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	reg, _ := regexp.Compile("^/_register$")
+	s.handler.HandleFunc(reg, s.RegisterHandler)
+
+	reg, _ = regexp.Compile("^/_resource$")
+	s.handler.HandleFunc(reg, s.RegisterResourceHandler)
+
+	reg, _ = regexp.Compile("^/_$")
+	s.handler.HandleFunc(reg, s.HomeHandler)
+
+	reg, _ = regexp.Compile("^/_history$")
+	s.handler.HandleFunc(reg, s.HistoryHandler)
+
+	reg, _ = regexp.Compile("^/_unmatched$")
+	s.handler.HandleFunc(reg, s.UnmatchedHandler)
+
+	reg, _ = regexp.Compile("^/_reset$")
+	s.handler.HandleFunc(reg, s.ResetHandler)
+
+	reg, _ = regexp.Compile("^/_scenarios$")
+	s.handler.HandleFunc(reg, s.ScenariosHandler)
+
+	reg, _ = regexp.Compile("^/_scenarios/reset$")
+	s.handler.HandleFunc(reg, s.ResetScenariosHandler)
+
+	reg, _ = regexp.Compile("^/_session$")
+	s.handler.HandleFunc(reg, s.SessionHandler)
+
+	reg, _ = regexp.Compile("^.+")
+	s.handler.HandleFunc(reg, s.DynamicEndpoint)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, err := s.withSession(r)
+	if err != nil {
+		RenderJSON(w, http.StatusBadRequest, NewResponse(err.Error()))
+		return
+	}
+	s.handler.ServeHTTP(w, r)
+}
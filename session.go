@@ -0,0 +1,171 @@
+package apidemic
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pmylund/go-cache"
+)
+
+// SessionHeader is the header selecting the session of a request.
+const SessionHeader = "X-Apidemic-Session"
+
+// sessionPathRegexp matches the path prefix selecting the session of a request, the rest
+// of the path is routed as usual.
+//
+//	Example "/_session/ci-42/_register" or "/_session/ci-42/users/1"
+var sessionPathRegexp = regexp.MustCompile(`^/_session/([A-Za-z0-9_.-]+)(/.*)?$`)
+
+// sessionIDRegexp matches the session ids of the SessionHeader.
+var sessionIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+const (
+	// sessionIdleTime is how long a session lives without any request, the default one
+	// excepted.
+	sessionIdleTime = 30 * time.Minute
+	// sweepInterval is how often the expired items of the sessions and the idle sessions are
+	// dropped, see sweepSessions.
+	sweepInterval = 30 * time.Second
+)
+
+// session is a namespace of registered endpoints and history. Requests without a session
+// use the default one, the others are created when something is registered in them and
+// dropped on reset or once idle for sessionIdleTime.
+type session struct {
+	store     *cache.Cache
+	events    *cache.Cache
+	eventSeq  uint64
+	scenarios *scenarios
+	used      time.Time
+
+	mu        sync.Mutex
+	resources map[string]*collection
+}
+
+// newSession returns an empty session. Its caches have no janitor, their expired items are
+// dropped by the sweeps of the server.
+func newSession() *session {
+	return &session{
+		store:     cache.New(5*time.Minute, 0),
+		events:    cache.New(5*time.Minute, 0),
+		scenarios: newScenarios(),
+		resources: make(map[string]*collection),
+	}
+}
+
+type sessionKey struct{}
+
+// withSession returns r bound to the id of its session, the session path prefix being
+// stripped. It fails when the SessionHeader is not a valid session id.
+func (s *Server) withSession(r *http.Request) (*http.Request, error) {
+	id := r.Header.Get(SessionHeader)
+	if id != "" && !sessionIDRegexp.MatchString(id) {
+		return nil, fmt.Errorf("apidemic: bad session id %q, expected letters, digits, '_', '.' and '-'", id)
+	}
+	if m := sessionPathRegexp.FindStringSubmatch(r.URL.Path); m != nil {
+		id = m[1]
+		u := *r.URL
+		u.Path, u.RawPath = m[2], ""
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		sr := *r
+		sr.URL = &u
+		r = &sr
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), sessionKey{}, id)), nil
+}
+
+// getSession returns the session id. A session which does not exist is created when create
+// is set, otherwise an empty session is returned which is not kept, so that looking up an
+// unknown session does not leave it behind.
+func (s *Server) getSession(id string, create bool) *session {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	now := time.Now()
+	if now.Sub(s.swept) >= sweepInterval {
+		s.sweepSessions(now)
+	}
+	ss, ok := s.sessions[id]
+	if !ok {
+		if !create {
+			return newSession()
+		}
+		ss = newSession()
+		s.sessions[id] = ss
+	}
+	ss.used = now
+	return ss
+}
+
+// sweepSessions drops the expired items of the sessions and the sessions idle for
+// sessionIdleTime. It expects sessionsMu to be held.
+func (s *Server) sweepSessions(now time.Time) {
+	s.swept = now
+	for id, ss := range s.sessions {
+		if id != "" && now.Sub(ss.used) >= sessionIdleTime {
+			delete(s.sessions, id)
+			continue
+		}
+		ss.store.DeleteExpired()
+		ss.events.DeleteExpired()
+	}
+}
+
+// session returns the session r is bound to, see getSession.
+func (s *Server) session(r *http.Request) *session {
+	id, _ := r.Context().Value(sessionKey{}).(string)
+	return s.getSession(id, false)
+}
+
+// sessionToWrite returns the session r is bound to, creating it when needed.
+func (s *Server) sessionToWrite(r *http.Request) *session {
+	id, _ := r.Context().Value(sessionKey{}).(string)
+	return s.getSession(id, true)
+}
+
+// resetSession drops the registered endpoints and resources, the history and the scenario
+// states of the session r is bound to.
+func (s *Server) resetSession(r *http.Request) {
+	ss := s.session(r)
+	ss.events.Flush()
+	ss.store.Flush()
+	ss.scenarios.reset()
+	ss.mu.Lock()
+	ss.resources = make(map[string]*collection)
+	ss.mu.Unlock()
+
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	for id, item := range s.sessions {
+		if item == ss && id != "" {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// SessionHandler creates a new session and renders its id.
+func (s *Server) SessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := newSessionID()
+	s.getSession(id, true)
+
+	RenderJSON(w, http.StatusCreated, struct {
+		Session string `json:"session"`
+	}{id})
+}
+
+// newSessionID returns a random session id.
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
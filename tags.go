@@ -2,8 +2,12 @@ package apidemic
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/icrowley/fake"
 )
 
 var ErrTagNotFound = errors.New("apidemic: Tag not found")
@@ -93,6 +97,24 @@ var fieldTags = struct {
 	WordsN                    string
 	Year                      string
 	Zip                       string
+	Int                       string
+	Float                     string
+	Decimal                   string
+	Date                      string
+	DateTime                  string
+	Time                      string
+	Unix                      string
+	UnixMs                    string
+	UUID                      string
+	ULID                      string
+	ObjectID                  string
+	Hex                       string
+	SHA256                    string
+	Slug                      string
+	Sequence                  string
+	OneOf                     string
+	Bool                      string
+	Pattern                   string
 }{
 	"brand", "character", "characters", "characters_n",
 	"city", "color", "company", "continent", "country",
@@ -115,6 +137,10 @@ var fieldTags = struct {
 	"sentences", "sentences_n", "simple_pass_word", "state", "state_abbrev",
 	"street", "street_address", "title", "top_level_domain", "user_name", "week_day",
 	"week_day_short", "week_day_num", "word", "words", "words_n", "year", "zip",
+	"int", "float", "decimal",
+	"date", "datetime", "time", "unix", "unix_ms",
+	"uuid", "ulid", "object_id", "hex", "sha256", "slug", "sequence",
+	"one_of", "bool", "pattern",
 }
 
 //Tags stores metadata about values
@@ -128,19 +154,23 @@ type Tags map[string]string
 //
 // For instance in the example above, the value is characters, where max=30 limits the number of characters
 // to the maximum size of 30.
+//
+// Options holding a list separate its items with |, like "one_of,values=a|b|c". Single or
+// double quotes keep commas, equal signs and bars as they are, like "date,format='Jan 2, 2006'".
 func (t Tags) Load(src string) {
-	ss := strings.Split(src, ",")
-	first := strings.TrimSpace(ss[0])
+	ss := splitUnquoted(src, ',')
+	first := unquote(strings.TrimSpace(ss[0]))
 	if len(ss) > 0 {
 		t["type"] = first
 		rest := ss[1:]
 		for _, v := range rest {
-			ts := strings.Split(v, "=")
+			ts := splitUnquoted(v, '=')
 			if len(ts) < 2 {
-				t[v] = ""
+				t[unquote(strings.TrimSpace(v))] = ""
 				continue
 			}
-			t[strings.TrimSpace(ts[0])] = strings.TrimSpace(ts[1])
+			key := unquote(strings.TrimSpace(ts[0]))
+			t[key] = strings.TrimSpace(strings.TrimPrefix(v[len(ts[0]):], "="))
 		}
 	}
 
@@ -149,7 +179,20 @@ func (t Tags) Load(src string) {
 // Get returns the value for tag key.
 func (t Tags) Get(key string) (string, bool) {
 	k, ok := t[key]
-	return k, ok
+	return unquote(k), ok
+}
+
+// List returns the items of the list value for tag key.
+func (t Tags) List(key string) ([]string, bool) {
+	v, ok := t[key]
+	if !ok {
+		return nil, false
+	}
+	items := splitUnquoted(v, '|')
+	for i, item := range items {
+		items[i] = unquote(strings.TrimSpace(item))
+	}
+	return items, true
 }
 
 // Int returns an in value for tag key.
@@ -161,6 +204,15 @@ func (t Tags) Int(key string) (int, error) {
 	return strconv.Atoi(tag)
 }
 
+// Float returns a float value for tag key.
+func (t Tags) Float(key string) (float64, error) {
+	tag, ok := t.Get(key)
+	if !ok {
+		return 0, ErrTagNotFound
+	}
+	return strconv.ParseFloat(tag, 64)
+}
+
 // Bool returns a boolean value for tag key
 func (t Tags) Bool(key string) (bool, error) {
 	tag, ok := t.Get(key)
@@ -169,3 +221,224 @@ func (t Tags) Bool(key string) (bool, error) {
 	}
 	return strconv.ParseBool(tag)
 }
+
+// splitKey splits an annotated object key like "name:full_name" into the key and its tags.
+func splitKey(key string) (string, string, bool) {
+	sections := strings.SplitN(key, ":", 2)
+	if len(sections) != 2 {
+		return key, "", false
+	}
+	return sections[0], sections[1], true
+}
+
+// splitUnquoted splits s around the separators which are not quoted.
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes of s, like 'a, b' or x"|"y.
+func unquote(s string) string {
+	if !strings.ContainsAny(s, "'\"") {
+		return s
+	}
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// unbalanced reports whether s has a quote which is not closed.
+func unbalanced(s string) bool {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		}
+	}
+	return quote != 0
+}
+
+// maxArrayCount limits the number of items of a tagged array.
+const maxArrayCount = 10000
+
+// expands reports whether the tags make an array of items generated from its first one,
+// which is when they have a type, a count or a max.
+func (t Tags) expands() bool {
+	typ, _ := t.Get("type")
+	_, hasCount := t.Get("count")
+	_, hasMax := t.Get("max")
+	return typ != "" || hasCount || hasMax
+}
+
+// arraySpec reads how a tagged array of n sample items is generated from its first item. It
+// returns the number of items and their tags. The number is set by count, else by max unless
+// the items are numbers, else it is n. The max of numbers is the upper bound of the items, so
+// an array of numbers with a max must have a count. The rates apply to the array itself and
+// the other tags to its items.
+//
+//	Example "list:word,count=3" or "scores:int,min=1,max=5,count=10"
+func (t Tags) arraySpec(n int) (int, Tags, error) {
+	typ, _ := t.Get("type")
+	lengthKey := "count"
+	if _, ok := t.Get("count"); !ok {
+		switch typ {
+		case fieldTags.Int, fieldTags.Float, fieldTags.Decimal:
+			if _, ok := t.Get("max"); ok {
+				return 0, nil, fmt.Errorf("bad max %q of an array of %s, expected a count of items along with it", t["max"], typ)
+			}
+		default:
+			lengthKey = "max"
+		}
+	}
+	if _, ok := t.Get(lengthKey); ok {
+		v, err := t.Int(lengthKey)
+		if err != nil || v < 0 || v > maxArrayCount {
+			return 0, nil, fmt.Errorf("bad %s %q, expected a number of items up to %d", lengthKey, t[lengthKey], maxArrayCount)
+		}
+		n = v
+	}
+
+	tags := make(Tags)
+	for key, val := range t {
+		switch key {
+		case lengthKey, "count", "null_rate", "omit_rate":
+		default:
+			tags[key] = val
+		}
+	}
+	return n, tags, nil
+}
+
+// checkTags checks the tags of every object key found in data, so that bad tags are
+// reported at registration time.
+func checkTags(data interface{}) error {
+	switch val := data.(type) {
+	case map[string]interface{}:
+		for key, item := range val {
+			if _, src, ok := splitKey(key); ok {
+				tags := make(Tags)
+				tags.Load(src)
+				if err := checkKeyTags(tags, item); err != nil {
+					return fmt.Errorf("apidemic: bad tags in %q: %s", key, err)
+				}
+			}
+			if err := checkTags(item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range val {
+			if err := checkTags(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkKeyTags checks the tags of a key holding item. The tags of an array generated from
+// its first item are checked as the tags of the items.
+func checkKeyTags(tags Tags, item interface{}) error {
+	arr, ok := item.([]interface{})
+	if !ok || !tags.expands() {
+		return tags.check()
+	}
+	if _, _, err := tags.rates(); err != nil {
+		return err
+	}
+	_, itemTags, err := tags.arraySpec(len(arr))
+	if err != nil {
+		return err
+	}
+	return itemTags.check()
+}
+
+// check reports the tag options which cannot be used to generate fake data.
+func (t Tags) check() error {
+	for key, val := range t {
+		if unbalanced(val) {
+			return fmt.Errorf("unclosed quote in %s", key)
+		}
+	}
+	if lang, ok := t.lang(); ok {
+		if err := CheckLang(lang); err != nil {
+			return err
+		}
+	}
+	if _, _, err := t.rates(); err != nil {
+		return err
+	}
+	_, err := t.spec()
+	return err
+}
+
+// spec parses the options of the type of t, it returns nil for the types without options.
+// The spec is a numberSpec, timeSpec, yearSpec, choiceSpec, sequenceSpec, the probability of
+// a bool, the regexp of a pattern, or the int option of the other identifier tags.
+func (t Tags) spec() (interface{}, error) {
+	switch typ, _ := t.Get("type"); typ {
+	case fieldTags.Int, fieldTags.Float, fieldTags.Decimal:
+		return t.numberSpec(typ)
+	case fieldTags.Date, fieldTags.DateTime, fieldTags.Time, fieldTags.Unix, fieldTags.UnixMs:
+		return t.timeSpec(typ)
+	case fieldTags.Year:
+		return t.yearSpec()
+	case fieldTags.UUID, fieldTags.ULID, fieldTags.ObjectID, fieldTags.Hex, fieldTags.SHA256, fieldTags.Slug, fieldTags.Sequence:
+		return t.idSpec(typ)
+	case fieldTags.OneOf:
+		return t.choiceSpec()
+	case fieldTags.Bool:
+		return t.probability()
+	case fieldTags.Pattern:
+		return t.patternRegexp()
+	}
+	return nil, nil
+}
+
+// lang returns the language of the fake data, set by either the lang or the locale option.
+func (t Tags) lang() (string, bool) {
+	if lang, ok := t.Get("lang"); ok {
+		return lang, true
+	}
+	return t.Get("locale")
+}
+
+// CheckLang reports whether fake data can be generated in lang.
+func CheckLang(lang string) error {
+	langs := fake.GetLangs()
+	for _, l := range langs {
+		if l == lang {
+			return nil
+		}
+	}
+	sort.Strings(langs)
+	return fmt.Errorf("unknown lang %q, expected one of %v", lang, langs)
+}
@@ -2,6 +2,8 @@ package apidemic
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestTags(t *testing.T) {
@@ -33,3 +35,28 @@ func TestTags(t *testing.T) {
 		t.Errorf("expected %d got %d", 30, max)
 	}
 }
+
+func TestCheckTags(t *testing.T) {
+	assert.NoError(t, checkTags(map[string]interface{}{
+		"name:full_name,lang=ru": "",
+		"user":                   map[string]interface{}{"city:city,locale=en": ""},
+	}))
+	assert.Error(t, checkTags(map[string]interface{}{
+		"users": []interface{}{map[string]interface{}{"name:full_name,lang=xx": ""}},
+	}))
+}
+
+func TestTagsQuoting(t *testing.T) {
+	tags := make(Tags)
+	tags.Load(`date, format='Jan 2, 2006', values=a|"b|c"|'d=e' ,flag`)
+
+	typ, _ := tags.Get("type")
+	assert.Equal(t, "date", typ)
+	format, _ := tags.Get("format")
+	assert.Equal(t, "Jan 2, 2006", format)
+	values, ok := tags.List("values")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a", "b|c", "d=e"}, values)
+	_, ok = tags.Get("flag")
+	assert.True(t, ok)
+}
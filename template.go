@@ -0,0 +1,172 @@
+package apidemic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateData is what the templates of a response can refer to. Query and Headers hold
+// the first value of every query parameter and header. Body is the decoded JSON request
+// body, or the raw body when it is not JSON.
+//
+//	Example "{{ .Params.id }}", "{{ .Query.page }}" or "{{ .Body.user.name }}"
+type templateData struct {
+	Method  string
+	Path    string
+	Params  map[string]string
+	Query   map[string]string
+	Headers map[string]string
+	Body    interface{}
+
+	gen       *generator
+	templates templates
+}
+
+func newTemplateData(r *http.Request, body []byte, params map[string]string, gen *generator) *templateData {
+	data := &templateData{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Params:  params,
+		Query:   make(map[string]string),
+		Headers: make(map[string]string),
+		Body:    string(body),
+		gen:     gen,
+	}
+	for key, values := range r.URL.Query() {
+		data.Query[key] = values[0]
+	}
+	for key, values := range r.Header {
+		data.Headers[key] = values[0]
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err == nil {
+		data.Body = decoded
+	}
+	return data
+}
+
+func (d *templateData) funcs() template.FuncMap {
+	return template.FuncMap{
+		"now": func(layout ...string) string {
+			if len(layout) > 0 {
+				return time.Now().Format(layout[0])
+			}
+			return time.Now().Format(time.RFC3339)
+		},
+		"uuid": func() string {
+			return newUUID(d.gen.rand)
+		},
+		"random": func(min, max int) int {
+			if max <= min {
+				return min
+			}
+			return min + d.gen.rand.Intn(max-min+1)
+		},
+	}
+}
+
+// parseTemplate parses src, which is a template only when it holds an action.
+func parseTemplate(src string, funcs template.FuncMap) (*template.Template, error) {
+	if !strings.Contains(src, "{{") {
+		return nil, nil
+	}
+	return template.New("").Funcs(funcs).Option("missingkey=error").Parse(src)
+}
+
+// templates holds parsed templates by their source, so that the templates of a response are
+// parsed once at registration rather than for every request.
+type templates map[string]*template.Template
+
+// parse parses every template found in the strings of data into t, so that broken templates
+// are reported at registration time.
+func (t templates) parse(data interface{}) error {
+	switch val := data.(type) {
+	case string:
+		tmpl, err := parseTemplate(val, (&templateData{}).funcs())
+		if err != nil {
+			return fmt.Errorf("apidemic: bad template %q: %s", val, err)
+		}
+		if tmpl != nil {
+			t[val] = tmpl
+		}
+	case map[string]interface{}:
+		for _, item := range val {
+			if err := t.parse(item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range val {
+			if err := t.parse(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renderTemplates returns a copy of data where every template is replaced by its output.
+func renderTemplates(data interface{}, d *templateData) (interface{}, error) {
+	switch val := data.(type) {
+	case string:
+		return renderTemplate(val, d)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		// Keys are sorted for the templates to draw from the generator in a stable order.
+		for _, key := range sortedKeys(val) {
+			rendered, err := renderTemplates(val[key], d)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			rendered, err := renderTemplates(item, d)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	}
+	return data, nil
+}
+
+// renderTemplate renders src for the request described by d when it is one of the templates
+// parsed at registration, with the functions of d. Any other string is returned as is, so
+// that generated data looking like a template, like "{{ x }}", is never executed.
+func renderTemplate(src string, d *templateData) (string, error) {
+	tmpl, ok := d.templates[src]
+	if !ok {
+		return src, nil
+	}
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return "", fmt.Errorf("apidemic: render template %q failed: %s", src, err)
+	}
+	tmpl = tmpl.Funcs(d.funcs())
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d); err != nil {
+		return "", fmt.Errorf("apidemic: render template %q failed: %s", src, err)
+	}
+	return buf.String(), nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID(r *rand.Rand) string {
+	b := make([]byte, 16)
+	r.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
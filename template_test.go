@@ -0,0 +1,69 @@
+package apidemic
+
+import (
+	"math/rand"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	req, err := http.NewRequest("POST", "/users/1?page=2", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Request-Id", "abc")
+
+	data := newTemplateData(req, []byte(`{"user":{"name":"anton"}}`), map[string]string{"id": "1"}, newGenerator(nil))
+	payload := map[string]interface{}{
+		"id":      "{{ .Params.id }}",
+		"page":    "{{ .Query.page }}",
+		"request": `{{ index .Headers "X-Request-Id" }}`,
+		"names":   []interface{}{"{{ .Body.user.name }}", "static"},
+		"age":     float64(29),
+	}
+	data.templates = make(templates)
+	require.NoError(t, data.templates.parse(payload))
+	require.NoError(t, data.templates.parse("{{ .Body.missing }}"))
+	out, err := renderTemplates(payload, data)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"id":      "1",
+		"page":    "2",
+		"request": "abc",
+		"names":   []interface{}{"anton", "static"},
+		"age":     float64(29),
+	}, out)
+
+	_, err = renderTemplates("{{ .Body.missing }}", data)
+	assert.Error(t, err)
+
+	assert.Error(t, make(templates).parse(map[string]interface{}{"id": "{{ .Params.id "}))
+}
+
+func TestRenderParsedTemplates(t *testing.T) {
+	tmpls := make(templates)
+	require.NoError(t, tmpls.parse(map[string]interface{}{"id": "{{ uuid }}-{{ .Params.id }}", "static": "x"}))
+	require.Len(t, tmpls, 1)
+
+	req, err := http.NewRequest("GET", "/users/1", nil)
+	require.NoError(t, err)
+	seed := int64(7)
+	render := func() string {
+		data := newTemplateData(req, nil, map[string]string{"id": "1"}, newGenerator(&seed))
+		data.templates = tmpls
+		out, err := renderTemplate("{{ uuid }}-{{ .Params.id }}", data)
+		require.NoError(t, err)
+		return out
+	}
+	first := render()
+	assert.Regexp(t, `^[0-9a-f-]{36}-1$`, first)
+	assert.Equal(t, first, render())
+}
+
+func TestNewUUID(t *testing.T) {
+	uuid := newUUID(rand.New(rand.NewSource(1)))
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), uuid)
+}